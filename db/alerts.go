@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"trailcall/models"
+)
+
+// Alert operations
+
+// ErrAlertResolved is returned when resolving an alert a second time
+var ErrAlertResolved = errors.New("alert already resolved")
+
+// GetEmergencySheet builds the emergency sheet for a hike from its current
+// check-ins: who is still out, and who led and swept the group
+func GetEmergencySheet(hikeID int64) (*models.EmergencySheet, error) {
+	hike, err := GetHikeByID(hikeID)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &models.EmergencySheet{
+		HikeID:      hike.ID,
+		HikeName:    hike.Name,
+		HikeDate:    hike.Date,
+		Location:    hike.Location,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Leaders:     []models.EmergencySheetPerson{},
+		Sweepers:    []models.EmergencySheetPerson{},
+		Outstanding: []models.EmergencySheetPerson{},
+	}
+
+	rows, err := DB.Query(`
+		SELECT m.first_name || ' ' || m.last_name, m.membership_number, m.phone,
+		       c.is_leader, c.is_sweeper, c.checked_in_at, c.checked_out_at
+		FROM checkins c
+		JOIN members m ON c.member_id = m.id
+		WHERE c.hike_id = ?
+		ORDER BY m.last_name, m.first_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.EmergencySheetPerson
+		var phone sql.NullString
+		var checkedOutAt sql.NullTime
+		if err := rows.Scan(&p.Name, &p.MembershipNumber, &phone, &p.IsLeader, &p.IsSweeper, &p.CheckedInAt, &checkedOutAt); err != nil {
+			return nil, err
+		}
+		p.Phone = phone.String
+		if checkedOutAt.Valid {
+			p.CheckedOutAt = &checkedOutAt.Time
+		}
+		sheet.Started++
+		if p.IsLeader {
+			sheet.Leaders = append(sheet.Leaders, p)
+		}
+		if p.IsSweeper {
+			sheet.Sweepers = append(sheet.Sweepers, p)
+		}
+		if p.CheckedOutAt == nil {
+			sheet.Outstanding = append(sheet.Outstanding, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Guests have no phone number on record
+	guestRows, err := DB.Query(`
+		SELECT guest_name, checked_in_at, checked_out_at
+		FROM rsvps
+		WHERE hike_id = ? AND member_id IS NULL AND checked_in_at IS NOT NULL
+		ORDER BY guest_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer guestRows.Close()
+
+	for guestRows.Next() {
+		p := models.EmergencySheetPerson{IsGuest: true}
+		var checkedOutAt sql.NullTime
+		if err := guestRows.Scan(&p.Name, &p.CheckedInAt, &checkedOutAt); err != nil {
+			return nil, err
+		}
+		sheet.Started++
+		if checkedOutAt.Valid {
+			continue
+		}
+		sheet.Outstanding = append(sheet.Outstanding, p)
+	}
+	return sheet, guestRows.Err()
+}
+
+// CreateAlert records an alert for a hike along with a snapshot of its emergency sheet
+func CreateAlert(hikeID int64, alertType, notes string, raisedBy int64, sheet *models.EmergencySheet) (*models.HikeAlert, error) {
+	sheetJSON, err := json.Marshal(sheet)
+	if err != nil {
+		return nil, err
+	}
+	result, err := DB.Exec(
+		"INSERT INTO hike_alerts (hike_id, type, notes, sheet_json, raised_by) VALUES (?, ?, ?, ?, ?)",
+		hikeID, alertType, notes, string(sheetJSON), raisedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetAlertByID(id)
+}
+
+const alertColumns = `
+	SELECT a.id, a.hike_id, a.type, a.notes, a.sheet_json,
+	       COALESCE(ru.username, ''), a.raised_at,
+	       COALESCE(su.username, ''), a.resolved_at, a.resolution_notes, h.name
+	FROM hike_alerts a
+	JOIN hikes h ON a.hike_id = h.id
+	LEFT JOIN users ru ON a.raised_by = ru.id
+	LEFT JOIN users su ON a.resolved_by = su.id
+`
+
+func scanAlert(row interface{ Scan(...interface{}) error }, withSheet bool) (*models.HikeAlert, error) {
+	var a models.HikeAlert
+	var notes, sheetJSON, resolutionNotes sql.NullString
+	var resolvedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.HikeID, &a.Type, &notes, &sheetJSON, &a.RaisedBy, &a.RaisedAt,
+		&a.ResolvedBy, &resolvedAt, &resolutionNotes, &a.HikeName)
+	if err != nil {
+		return nil, err
+	}
+	a.Notes = notes.String
+	a.ResolutionNotes = resolutionNotes.String
+	if resolvedAt.Valid {
+		a.ResolvedAt = &resolvedAt.Time
+	}
+	if withSheet && sheetJSON.Valid {
+		var sheet models.EmergencySheet
+		if err := json.Unmarshal([]byte(sheetJSON.String), &sheet); err == nil {
+			a.Sheet = &sheet
+		}
+	}
+	return &a, nil
+}
+
+// GetAlertByID returns an alert with the emergency sheet captured when it was raised
+func GetAlertByID(id int64) (*models.HikeAlert, error) {
+	return scanAlert(DB.QueryRow(alertColumns+" WHERE a.id = ?", id), true)
+}
+
+// GetAlerts lists alerts newest first. hikeID 0 means all hikes; status is
+// "open", "resolved" or empty for both. Sheets are left out of lists.
+func GetAlerts(hikeID int64, status string) ([]models.HikeAlert, error) {
+	query := alertColumns + " WHERE 1=1"
+	var args []interface{}
+	if hikeID != 0 {
+		query += " AND a.hike_id = ?"
+		args = append(args, hikeID)
+	}
+	switch status {
+	case "open":
+		query += " AND a.resolved_at IS NULL"
+	case "resolved":
+		query += " AND a.resolved_at IS NOT NULL"
+	}
+	query += " ORDER BY a.raised_at DESC, a.id DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.HikeAlert
+	for rows.Next() {
+		a, err := scanAlert(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, *a)
+	}
+	return alerts, rows.Err()
+}
+
+// ResolveAlert closes an alert with notes on how it ended
+func ResolveAlert(id, resolvedBy int64, notes string) (*models.HikeAlert, error) {
+	result, err := DB.Exec(
+		"UPDATE hike_alerts SET resolved_at = CURRENT_TIMESTAMP, resolved_by = ?, resolution_notes = ? WHERE id = ? AND resolved_at IS NULL",
+		resolvedBy, notes, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := GetAlertByID(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrAlertResolved
+	}
+	return GetAlertByID(id)
+}
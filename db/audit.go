@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+
+	"trailcall/models"
+)
+
+// Audit log operations
+
+func CreateAuditEntry(e models.AuditEntry) error {
+	var before, after sql.NullString
+	if len(e.Before) > 0 {
+		before = sql.NullString{String: string(e.Before), Valid: true}
+	}
+	if len(e.After) > 0 {
+		after = sql.NullString{String: string(e.After), Valid: true}
+	}
+	_, err := DB.Exec(
+		"INSERT INTO audit_log (user_id, actor, action, entity_type, entity_id, before_json, after_json) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.UserID, e.Actor, e.Action, e.EntityType, e.EntityID, before, after,
+	)
+	return err
+}
+
+// GetAuditEntries returns audit entries matching the filter, newest first
+func GetAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	query := "SELECT id, user_id, actor, action, entity_type, entity_id, before_json, after_json, created_at FROM audit_log WHERE 1 = 1"
+	var args []interface{}
+
+	if f.EntityType != "" {
+		query += " AND entity_type = ?"
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID != nil {
+		query += " AND entity_id = ?"
+		args = append(args, *f.EntityID)
+	}
+	if f.From != "" {
+		query += " AND date(created_at) >= date(?)"
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		query += " AND date(created_at) <= date(?)"
+		args = append(args, f.To)
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var userID, entityID sql.NullInt64
+		var before, after sql.NullString
+		err := rows.Scan(&e.ID, &userID, &e.Actor, &e.Action, &e.EntityType, &entityID, &before, &after, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			e.UserID = &userID.Int64
+		}
+		if entityID.Valid {
+			e.EntityID = &entityID.Int64
+		}
+		if before.Valid {
+			e.Before = []byte(before.String)
+		}
+		if after.Valid {
+			e.After = []byte(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
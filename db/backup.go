@@ -0,0 +1,183 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup writes a consistent copy of the live database to destPath using
+// VACUUM INTO, which is safe to run while the server is handling requests
+func Backup(destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("%s already exists", destPath)
+	}
+	_, err := DB.Exec("VACUUM INTO ?", destPath)
+	return err
+}
+
+// BackupToTemp writes a backup next to the live database and returns its path.
+// The caller is responsible for removing the file.
+func BackupToTemp() (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dbFilePath), ".trailcall-backup-*.db")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+	// VACUUM INTO refuses to overwrite, so only reserve the name
+	os.Remove(path)
+
+	if err := Backup(path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// ValidateBackup checks that path is an intact TrailCall database this build
+// can run against, and returns its schema version
+func ValidateBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var integrity string
+	if err := conn.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return 0, fmt.Errorf("not a readable SQLite database: %w", err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", integrity)
+	}
+
+	var version sql.NullInt64
+	if err := conn.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("no schema version found, not a TrailCall backup: %w", err)
+	}
+	if !version.Valid {
+		return 0, fmt.Errorf("no migrations recorded, not a TrailCall backup")
+	}
+	if int(version.Int64) > LatestVersion() {
+		return 0, fmt.Errorf("backup is at schema version %d but this build only knows up to %d; upgrade TrailCall first", version.Int64, LatestVersion())
+	}
+	return int(version.Int64), nil
+}
+
+// Restore validates srcPath and swaps it in as the database at dbPath. The
+// current database is kept alongside with a .pre-restore suffix. The server
+// must not be running.
+func Restore(srcPath, dbPath string) (string, error) {
+	if _, err := ValidateBackup(srcPath); err != nil {
+		return "", err
+	}
+
+	tmpPath := dbPath + ".restore-tmp"
+	if err := copyFile(srcPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	var keptPath string
+	if _, err := os.Stat(dbPath); err == nil {
+		keptPath = fmt.Sprintf("%s.pre-restore-%s", dbPath, time.Now().Format("20060102-150405"))
+		if err := os.Rename(dbPath, keptPath); err != nil {
+			os.Remove(tmpPath)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return keptPath, err
+	}
+	return keptPath, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// StartScheduledBackups writes a backup into dir every interval and keeps
+// only the newest keep files
+func StartScheduledBackups(dir string, interval time.Duration, keep int) error {
+	if interval <= 0 {
+		return fmt.Errorf("backup interval must be positive, got %s", interval)
+	}
+	if keep < 1 {
+		return fmt.Errorf("must keep at least one backup, got %d", keep)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			path := filepath.Join(dir, "trailcall-"+time.Now().Format("20060102-150405")+".db")
+			if err := Backup(path); err != nil {
+				log.Println("Scheduled backup failed:", err)
+				continue
+			}
+			log.Println("Scheduled backup written:", path)
+			if err := pruneBackups(dir, keep); err != nil {
+				log.Println("Failed to prune old backups:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// pruneBackups removes all but the newest keep scheduled backups in dir
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "trailcall-") && strings.HasSuffix(e.Name(), ".db") {
+			names = append(names, e.Name())
+		}
+	}
+	// Timestamped names sort oldest first
+	sort.Strings(names)
+
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
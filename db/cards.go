@@ -0,0 +1,161 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"trailcall/models"
+)
+
+// Membership cards
+//
+// With TRAILCALL_CARD_KEY set, card QR codes carry the membership number,
+// the member's card version and an HMAC over both:
+//
+//	TC1:<membership number>:<card version>:<signature>
+//
+// Bumping a member's card version revokes every card printed before it,
+// including any plain card. Plain membership numbers from older cards are
+// otherwise accepted until TRAILCALL_ACCEPT_PLAIN_CARDS is switched off.
+
+var (
+	// ErrInvalidCard is returned for a signed code that doesn't verify
+	ErrInvalidCard = errors.New("card signature is not valid")
+	// ErrCardRevoked is returned for a card printed before the member's current card version
+	ErrCardRevoked = errors.New("card has been replaced")
+	// ErrPlainCard is returned for a bare membership number when only signed cards are accepted
+	ErrPlainCard = errors.New("unsigned cards are not accepted")
+)
+
+const cardPrefix = "TC1:"
+
+// Signatures are truncated to 12 bytes to keep the QR code small
+const cardSignatureSize = 12
+
+var (
+	cardKey          []byte
+	acceptPlainCards = true
+)
+
+// SetCardKey configures card signing from a 32-byte key given as 64 hex
+// characters or base64
+func SetCardKey(value string) error {
+	key, err := parseKey(value)
+	if err != nil {
+		return err
+	}
+	cardKey = key
+	return nil
+}
+
+// SetAcceptPlainCards controls whether bare membership numbers are accepted at check-in
+func SetAcceptPlainCards(accept bool) {
+	acceptPlainCards = accept
+}
+
+// CardSigningEnabled reports whether a card key has been configured
+func CardSigningEnabled() bool {
+	return cardKey != nil
+}
+
+func cardSignature(membershipNumber string, version int) string {
+	mac := hmac.New(sha256.New, cardKey)
+	mac.Write([]byte(cardPrefix + membershipNumber + ":" + strconv.Itoa(version)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:cardSignatureSize])
+}
+
+// CardPayload is what a member's card QR code encodes: a signed payload when
+// signing is enabled, otherwise the plain membership number
+func CardPayload(member *models.Member) string {
+	if cardKey == nil {
+		return member.MembershipNumber
+	}
+	version := strconv.Itoa(member.CardVersion)
+	return cardPrefix + member.MembershipNumber + ":" + version + ":" + cardSignature(member.MembershipNumber, member.CardVersion)
+}
+
+// ResolveCardCode verifies a scanned code and returns the membership number
+// it stands for. allowPlain lets a leader's manual entry through even when
+// plain numbers are refused from cards, or the member's card was revoked.
+func ResolveCardCode(code string, allowPlain bool) (string, error) {
+	code = strings.TrimSpace(code)
+	rest, signed := strings.CutPrefix(code, cardPrefix)
+	if !signed {
+		if allowPlain {
+			return code, nil
+		}
+		if !acceptPlainCards {
+			return "", ErrPlainCard
+		}
+		// A revoked card must not keep working as a typed or plain number
+		var version int
+		err := DB.QueryRow("SELECT card_version FROM members WHERE membership_number = ?", code).Scan(&version)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if version > 1 {
+			return "", ErrCardRevoked
+		}
+		return code, nil
+	}
+	if cardKey == nil {
+		return "", ErrInvalidCard
+	}
+
+	// The membership number may itself contain colons, so split from the right
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return "", ErrInvalidCard
+	}
+	signature := rest[i+1:]
+	rest = rest[:i]
+	j := strings.LastIndex(rest, ":")
+	if j < 0 {
+		return "", ErrInvalidCard
+	}
+	membershipNumber := rest[:j]
+	version, err := strconv.Atoi(rest[j+1:])
+	if err != nil {
+		return "", ErrInvalidCard
+	}
+	if !hmac.Equal([]byte(signature), []byte(cardSignature(membershipNumber, version))) {
+		return "", ErrInvalidCard
+	}
+
+	var current int
+	if err := DB.QueryRow("SELECT card_version FROM members WHERE membership_number = ?", membershipNumber).Scan(&current); err != nil {
+		return "", err
+	}
+	if version != current {
+		return "", ErrCardRevoked
+	}
+	return membershipNumber, nil
+}
+
+// RevokeCard bumps a member's card version so cards printed so far stop
+// working. Self-service wallet links are cancelled too, since they would
+// otherwise hand out the new card to whoever holds the old link.
+func RevokeCard(memberID int64) (*models.Member, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE members SET card_version = card_version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", memberID)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteWalletLinks(tx, memberID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetMemberByID(memberID)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trailcall/models"
+)
+
+const testCardKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// openTestDB opens a migrated database in a temporary directory
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := Init(filepath.Join(t.TempDir(), "trailcall.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+}
+
+func createTestMember(t *testing.T, number string) *models.Member {
+	t.Helper()
+	member, err := CreateMember(models.CreateMemberRequest{MembershipNumber: number, FirstName: "Test", LastName: "Member"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return member
+}
+
+func TestResolveCardCode(t *testing.T) {
+	openTestDB(t)
+	if err := SetCardKey(testCardKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cardKey = nil
+		SetAcceptPlainCards(true)
+	})
+
+	plain := createTestMember(t, "ABC-1")
+	colons := createTestMember(t, "2024:17:x")
+	revoked := createTestMember(t, "ABC-2")
+	oldCard := CardPayload(revoked)
+	revoked, err := RevokeCard(revoked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCard := CardPayload(revoked)
+
+	valid := CardPayload(plain)
+	i := strings.LastIndex(valid, ":")
+	signature := valid[i+1:]
+	tampered := valid[:i+1] + strings.Repeat("A", len(signature))
+
+	tests := []struct {
+		name        string
+		code        string
+		allowPlain  bool
+		acceptPlain bool
+		want        string
+		wantErr     error
+	}{
+		{name: "signed", code: valid, acceptPlain: true, want: "ABC-1"},
+		{name: "surrounding space", code: " " + valid + "\n", acceptPlain: true, want: "ABC-1"},
+		{name: "colons in the number", code: CardPayload(colons), acceptPlain: true, want: "2024:17:x"},
+		{name: "tampered signature", code: tampered, acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "signature from another card", code: "TC1:ABC-2:1:" + signature, acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "version changed", code: "TC1:ABC-1:2:" + signature, acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "version not a number", code: "TC1:ABC-1:one:" + signature, acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "missing version", code: "TC1:" + signature, acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "bare prefix", code: "TC1:", acceptPlain: true, wantErr: ErrInvalidCard},
+		{name: "replaced card", code: oldCard, acceptPlain: true, wantErr: ErrCardRevoked},
+		{name: "replacement card", code: newCard, acceptPlain: true, want: "ABC-2"},
+		{name: "plain number", code: "ABC-1", acceptPlain: true, want: "ABC-1"},
+		{name: "plain number of a replaced card", code: "ABC-2", acceptPlain: true, wantErr: ErrCardRevoked},
+		{name: "plain cards switched off", code: "ABC-1", wantErr: ErrPlainCard},
+		{name: "manual entry with plain cards off", code: "ABC-1", allowPlain: true, want: "ABC-1"},
+		{name: "manual entry of a replaced card", code: "ABC-2", allowPlain: true, want: "ABC-2"},
+		{name: "unknown plain number", code: "NOBODY", acceptPlain: true, want: "NOBODY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetAcceptPlainCards(tt.acceptPlain)
+			got, err := ResolveCardCode(tt.code, tt.allowPlain)
+			if err != tt.wantErr {
+				t.Fatalf("ResolveCardCode(%q) error = %v, want %v", tt.code, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveCardCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("signed card for an unknown member", func(t *testing.T) {
+		code := CardPayload(&models.Member{MembershipNumber: "NOBODY", CardVersion: 1})
+		if _, err := ResolveCardCode(code, false); err != sql.ErrNoRows {
+			t.Errorf("error = %v, want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("signed card without a key", func(t *testing.T) {
+		cardKey = nil
+		if _, err := ResolveCardCode(valid, false); err != ErrInvalidCard {
+			t.Errorf("error = %v, want ErrInvalidCard", err)
+		}
+	})
+}
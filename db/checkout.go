@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"trailcall/models"
+)
+
+// Checkout operations
+//
+// A checkout confirms that someone who started a hike has come back. Members
+// are checked out on their check-in row; guests, who only have an RSVP, on
+// the RSVP row. Checking out twice keeps the first time.
+
+// ErrNotCheckedIn is returned when checking out someone who never checked in
+var ErrNotCheckedIn = errors.New("not checked in")
+
+// Checkout methods
+const (
+	CheckoutScan   = "scan"
+	CheckoutManual = "manual"
+)
+
+// CheckoutCheckin marks a check-in as returned
+func CheckoutCheckin(checkinID int64, method string) (*models.Checkin, error) {
+	var hikeID int64
+	if err := DB.QueryRow("SELECT hike_id FROM checkins WHERE id = ?", checkinID).Scan(&hikeID); err != nil {
+		return nil, err
+	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return nil, err
+	}
+
+	_, err := DB.Exec(
+		"UPDATE checkins SET checked_out_at = CURRENT_TIMESTAMP, checkout_method = ? WHERE id = ? AND checked_out_at IS NULL",
+		method, checkinID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetCheckinByID(checkinID)
+}
+
+// CheckoutMember checks out a member by membership number, e.g. from a QR re-scan
+func CheckoutMember(hikeID int64, membershipNumber, method string) (*models.Checkin, error) {
+	member, err := GetMemberByMembershipNumber(membershipNumber)
+	if err != nil {
+		return nil, err
+	}
+	checkinID, err := GetCheckinIDForMember(hikeID, member.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotCheckedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+	return CheckoutCheckin(*checkinID, method)
+}
+
+// UndoCheckout clears a member's checkout
+func UndoCheckout(checkinID int64) (*models.Checkin, error) {
+	var hikeID int64
+	if err := DB.QueryRow("SELECT hike_id FROM checkins WHERE id = ?", checkinID).Scan(&hikeID); err != nil {
+		return nil, err
+	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return nil, err
+	}
+
+	_, err := DB.Exec("UPDATE checkins SET checked_out_at = NULL, checkout_method = NULL WHERE id = ?", checkinID)
+	if err != nil {
+		return nil, err
+	}
+	return GetCheckinByID(checkinID)
+}
+
+// SetRSVPCheckedOut checks out (or undoes the checkout of) the person behind
+// an RSVP. Member RSVPs update the member's check-in; guests the RSVP itself.
+func SetRSVPCheckedOut(rsvpID int64, checkedOut bool) error {
+	var memberID sql.NullInt64
+	var hikeID int64
+	var checkedInAt sql.NullTime
+	err := DB.QueryRow("SELECT hike_id, member_id, checked_in_at FROM rsvps WHERE id = ?", rsvpID).Scan(&hikeID, &memberID, &checkedInAt)
+	if err != nil {
+		return err
+	}
+
+	if memberID.Valid {
+		checkinID, err := GetCheckinIDForMember(hikeID, memberID.Int64)
+		if err == sql.ErrNoRows {
+			return ErrNotCheckedIn
+		}
+		if err != nil {
+			return err
+		}
+		if checkedOut {
+			_, err = CheckoutCheckin(*checkinID, CheckoutManual)
+		} else {
+			_, err = UndoCheckout(*checkinID)
+		}
+		return err
+	}
+
+	if !checkedInAt.Valid {
+		return ErrNotCheckedIn
+	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return err
+	}
+	if checkedOut {
+		_, err = DB.Exec("UPDATE rsvps SET checked_out_at = CURRENT_TIMESTAMP WHERE id = ? AND checked_out_at IS NULL", rsvpID)
+	} else {
+		_, err = DB.Exec("UPDATE rsvps SET checked_out_at = NULL WHERE id = ?", rsvpID)
+	}
+	return err
+}
+
+// GetHeadcount lists everyone who started a hike and whether they have returned
+func GetHeadcount(hikeID int64) (*models.Headcount, error) {
+	hc := &models.Headcount{HikeID: hikeID, People: []models.HeadcountEntry{}}
+
+	rows, err := DB.Query(`
+		SELECT c.id, m.first_name || ' ' || m.last_name, m.membership_number,
+		       c.checked_in_at, c.checked_out_at
+		FROM checkins c
+		JOIN members m ON c.member_id = m.id
+		WHERE c.hike_id = ?
+		ORDER BY m.last_name, m.first_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.HeadcountEntry
+		var checkinID int64
+		var checkedOutAt sql.NullTime
+		if err := rows.Scan(&checkinID, &e.Name, &e.MembershipNumber, &e.CheckedInAt, &checkedOutAt); err != nil {
+			return nil, err
+		}
+		e.CheckinID = &checkinID
+		if checkedOutAt.Valid {
+			e.CheckedOutAt = &checkedOutAt.Time
+		}
+		hc.People = append(hc.People, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	guestRows, err := DB.Query(`
+		SELECT id, guest_name, checked_in_at, checked_out_at
+		FROM rsvps
+		WHERE hike_id = ? AND member_id IS NULL AND checked_in_at IS NOT NULL
+		ORDER BY guest_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer guestRows.Close()
+
+	for guestRows.Next() {
+		e := models.HeadcountEntry{IsGuest: true}
+		var rsvpID int64
+		var checkedOutAt sql.NullTime
+		if err := guestRows.Scan(&rsvpID, &e.Name, &e.CheckedInAt, &checkedOutAt); err != nil {
+			return nil, err
+		}
+		e.RSVPID = &rsvpID
+		if checkedOutAt.Valid {
+			e.CheckedOutAt = &checkedOutAt.Time
+		}
+		hc.People = append(hc.People, e)
+	}
+	if err := guestRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range hc.People {
+		hc.People[i].Returned = hc.People[i].CheckedOutAt != nil
+		if hc.People[i].Returned {
+			hc.Returned++
+		}
+	}
+	hc.Started = len(hc.People)
+	hc.Outstanding = hc.Started - hc.Returned
+	return hc, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field encryption
+//
+// Sensitive member details are sealed with AES-256-GCM before they are
+// written. The key comes from the environment and is never stored in the
+// database, so a copied database or backup doesn't expose them.
+
+// ErrNoEncryptionKey is returned when sensitive data is read or written
+// without TRAILCALL_ENCRYPTION_KEY configured
+var ErrNoEncryptionKey = errors.New("encryption key not configured")
+
+const encryptedPrefix = "v1:"
+
+var fieldCipher cipher.AEAD
+
+// parseKey decodes a 32-byte key given as 64 hex characters or base64
+func parseKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("key must be hex or base64")
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// SetEncryptionKey configures field encryption from a 32-byte key given as
+// 64 hex characters or base64
+func SetEncryptionKey(value string) error {
+	key, err := parseKey(value)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	fieldCipher = aead
+	return nil
+}
+
+// EncryptionEnabled reports whether a key has been configured
+func EncryptionEnabled() bool {
+	return fieldCipher != nil
+}
+
+// GenerateEncryptionKey returns a new random key in the form SetEncryptionKey
+// and SetCardKey expect
+func GenerateEncryptionKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
+// encryptField seals plaintext. The associated data ties the ciphertext to
+// its row so it can't be copied onto another record.
+func encryptField(plaintext []byte, associatedData string) (string, error) {
+	if fieldCipher == nil {
+		return "", ErrNoEncryptionKey
+	}
+	nonce := make([]byte, fieldCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := fieldCipher.Seal(nonce, nonce, plaintext, []byte(associatedData))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptField(value, associatedData string) ([]byte, error) {
+	if fieldCipher == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return nil, fmt.Errorf("unknown encrypted field format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	size := fieldCipher.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("encrypted field too short")
+	}
+	return fieldCipher.Open(nil, sealed[:size], sealed[size:], []byte(associatedData))
+}
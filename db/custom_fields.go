@@ -0,0 +1,180 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"trailcall/models"
+)
+
+// Custom member fields
+//
+// The club defines its own fields in custom_fields; each member's values are
+// rows in member_field_values, stored as text. Values are checked against the
+// field type by the handlers before they get here.
+
+const customFieldColumns = "id, key, label, type, required, options, position, created_at"
+
+func scanCustomField(scanner interface{ Scan(...interface{}) error }) (*models.CustomField, error) {
+	var f models.CustomField
+	var options sql.NullString
+	err := scanner.Scan(&f.ID, &f.Key, &f.Label, &f.Type, &f.Required, &options, &f.Position, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if options.Valid && options.String != "" {
+		if err := json.Unmarshal([]byte(options.String), &f.Options); err != nil {
+			return nil, err
+		}
+	}
+	return &f, nil
+}
+
+// encodeOptions stores a select field's choices as a JSON array, or NULL
+func encodeOptions(options []string) (sql.NullString, error) {
+	if len(options) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// GetCustomFields returns the field definitions in display order
+func GetCustomFields() ([]models.CustomField, error) {
+	rows, err := DB.Query("SELECT " + customFieldColumns + " FROM custom_fields ORDER BY position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []models.CustomField
+	for rows.Next() {
+		f, err := scanCustomField(rows)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, *f)
+	}
+	return fields, rows.Err()
+}
+
+func GetCustomFieldByID(id int64) (*models.CustomField, error) {
+	return scanCustomField(DB.QueryRow("SELECT "+customFieldColumns+" FROM custom_fields WHERE id = ?", id))
+}
+
+func CreateCustomField(req models.CustomFieldRequest) (*models.CustomField, error) {
+	options, err := encodeOptions(req.Options)
+	if err != nil {
+		return nil, err
+	}
+	result, err := DB.Exec(
+		"INSERT INTO custom_fields (key, label, type, required, options, position) VALUES (?, ?, ?, ?, ?, ?)",
+		req.Key, req.Label, req.Type, req.Required, options, req.Position,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetCustomFieldByID(id)
+}
+
+// UpdateCustomField changes a field's definition; the key stays the same
+func UpdateCustomField(id int64, req models.CustomFieldRequest) (*models.CustomField, error) {
+	options, err := encodeOptions(req.Options)
+	if err != nil {
+		return nil, err
+	}
+	_, err = DB.Exec(
+		"UPDATE custom_fields SET label = ?, type = ?, required = ?, options = ?, position = ? WHERE id = ?",
+		req.Label, req.Type, req.Required, options, req.Position, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetCustomFieldByID(id)
+}
+
+// DeleteCustomField removes a field along with every member's value for it
+func DeleteCustomField(id int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM member_field_values WHERE field_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM custom_fields WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMemberFieldValues returns a member's custom field values by field key
+func GetMemberFieldValues(memberID int64) (map[string]string, error) {
+	values, err := memberFieldValues("WHERE v.member_id = ?", memberID)
+	if err != nil {
+		return nil, err
+	}
+	return values[memberID], nil
+}
+
+// GetAllMemberFieldValues returns every member's custom field values, by
+// member ID and then field key
+func GetAllMemberFieldValues() (map[int64]map[string]string, error) {
+	return memberFieldValues("")
+}
+
+func memberFieldValues(where string, args ...interface{}) (map[int64]map[string]string, error) {
+	rows, err := DB.Query(`
+		SELECT v.member_id, f.key, v.value
+		FROM member_field_values v
+		JOIN custom_fields f ON v.field_id = f.id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[int64]map[string]string)
+	for rows.Next() {
+		var memberID int64
+		var key, value string
+		if err := rows.Scan(&memberID, &key, &value); err != nil {
+			return nil, err
+		}
+		if values[memberID] == nil {
+			values[memberID] = make(map[string]string)
+		}
+		values[memberID][key] = value
+	}
+	return values, rows.Err()
+}
+
+// setMemberFieldValues saves values by field key; an empty value removes the
+// member's value for that field
+func setMemberFieldValues(tx *sql.Tx, memberID int64, values map[string]string) error {
+	for key, value := range values {
+		var err error
+		if value == "" {
+			_, err = tx.Exec(`
+				DELETE FROM member_field_values
+				WHERE member_id = ? AND field_id = (SELECT id FROM custom_fields WHERE key = ?)
+			`, memberID, key)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO member_field_values (member_id, field_id, value)
+				SELECT ?, id, ? FROM custom_fields WHERE key = ?
+				ON CONFLICT (member_id, field_id) DO UPDATE SET value = excluded.value
+			`, memberID, value, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -13,8 +14,13 @@ import (
 
 var DB *sql.DB
 
-func Init(dbPath string) error {
+// dbFilePath is where the open database lives, used to place temporary backups
+var dbFilePath string
+
+// Open connects to the database without applying migrations
+func Open(dbPath string) error {
 	var err error
+	dbFilePath = dbPath
 	DB, err = sql.Open("sqlite", dbPath)
 	if err != nil {
 		return err
@@ -22,168 +28,121 @@ func Init(dbPath string) error {
 
 	// Enable foreign keys
 	_, err = DB.Exec("PRAGMA foreign_keys = ON")
-	if err != nil {
+	return err
+}
+
+// Init connects to the database and brings the schema up to date
+func Init(dbPath string) error {
+	if err := Open(dbPath); err != nil {
 		return err
 	}
-
 	return migrate()
 }
 
-func migrate() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS members (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		membership_number TEXT UNIQUE NOT NULL,
-		first_name TEXT NOT NULL,
-		last_name TEXT NOT NULL,
-		email TEXT,
-		phone TEXT,
-		active INTEGER DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS hikes (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		date TEXT NOT NULL,
-		location TEXT,
-		notes TEXT,
-		status TEXT DEFAULT 'open',
-		rsvp_open INTEGER DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS checkins (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		hike_id INTEGER NOT NULL,
-		member_id INTEGER NOT NULL,
-		checked_in_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		synced INTEGER DEFAULT 1,
-		FOREIGN KEY (hike_id) REFERENCES hikes(id),
-		FOREIGN KEY (member_id) REFERENCES members(id),
-		UNIQUE(hike_id, member_id)
-	);
-
-	CREATE TABLE IF NOT EXISTS rsvps (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		hike_id INTEGER NOT NULL,
-		member_id INTEGER,
-		guest_name TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (hike_id) REFERENCES hikes(id),
-		FOREIGN KEY (member_id) REFERENCES members(id),
-		UNIQUE(hike_id, member_id),
-		UNIQUE(hike_id, guest_name)
-	);
-
-	CREATE TABLE IF NOT EXISTS activities (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		hike_id INTEGER NOT NULL,
-		name TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (hike_id) REFERENCES hikes(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS activity_participants (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		activity_id INTEGER NOT NULL,
-		checkin_id INTEGER,
-		rsvp_id INTEGER,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (activity_id) REFERENCES activities(id) ON DELETE CASCADE,
-		FOREIGN KEY (checkin_id) REFERENCES checkins(id) ON DELETE CASCADE,
-		FOREIGN KEY (rsvp_id) REFERENCES rsvps(id) ON DELETE CASCADE,
-		UNIQUE(activity_id, checkin_id),
-		UNIQUE(activity_id, rsvp_id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_members_membership_number ON members(membership_number);
-	CREATE INDEX IF NOT EXISTS idx_checkins_hike_id ON checkins(hike_id);
-	CREATE INDEX IF NOT EXISTS idx_checkins_member_id ON checkins(member_id);
-	CREATE INDEX IF NOT EXISTS idx_rsvps_hike_id ON rsvps(hike_id);
-	CREATE INDEX IF NOT EXISTS idx_activities_hike_id ON activities(hike_id);
-	CREATE INDEX IF NOT EXISTS idx_activity_participants_activity_id ON activity_participants(activity_id);
-	`
-	_, err := DB.Exec(schema)
-	if err != nil {
-		return err
-	}
+// Member operations
 
-	// Migrations for existing databases
-	// Add rsvp_open column to hikes table (ignore error if already exists)
-	DB.Exec("ALTER TABLE hikes ADD COLUMN rsvp_open INTEGER DEFAULT 1")
-	// Add checked_in_at column to rsvps table for guest check-ins
-	DB.Exec("ALTER TABLE rsvps ADD COLUMN checked_in_at DATETIME")
-	// Add is_leader and is_sweeper columns to checkins table
-	DB.Exec("ALTER TABLE checkins ADD COLUMN is_leader INTEGER DEFAULT 0")
-	DB.Exec("ALTER TABLE checkins ADD COLUMN is_sweeper INTEGER DEFAULT 0")
+// memberColumns are selected by every member query and read by scanMember
+var memberColumns = `id, membership_number, first_name, last_name, email, phone, active, created_at, updated_at,
+	card_version, EXISTS(SELECT 1 FROM member_emergency_info e WHERE e.member_id = members.id),
+	(SELECT p.type FROM membership_periods p WHERE p.member_id = members.id ORDER BY p.start_date DESC, p.id DESC LIMIT 1),
+	(SELECT p.end_date FROM membership_periods p WHERE p.member_id = members.id ORDER BY p.start_date DESC, p.id DESC LIMIT 1),
+	` + lapsedOn("members.id", "date('now', 'localtime')") + `,
+	household_id, (SELECT h.name FROM households h WHERE h.id = members.household_id)`
 
-	return nil
+func scanMember(scanner interface{ Scan(...interface{}) error }) (*models.Member, error) {
+	var m models.Member
+	var email, phone, membershipType, membershipEnd, householdName sql.NullString
+	var householdID sql.NullInt64
+	err := scanner.Scan(&m.ID, &m.MembershipNumber, &m.FirstName, &m.LastName, &email, &phone, &m.Active, &m.CreatedAt, &m.UpdatedAt,
+		&m.CardVersion, &m.HasEmergencyInfo, &membershipType, &membershipEnd, &m.MembershipExpired, &householdID, &householdName)
+	if err != nil {
+		return nil, err
+	}
+	if householdID.Valid {
+		m.HouseholdID = &householdID.Int64
+	}
+	m.HouseholdName = householdName.String
+	m.Email = email.String
+	m.Phone = phone.String
+	m.MembershipType = membershipType.String
+	m.MembershipEnd = membershipEnd.String
+	return &m, nil
 }
 
-// Member operations
-
 func GetAllMembers(activeOnly bool) ([]models.Member, error) {
-	query := "SELECT id, membership_number, first_name, last_name, email, phone, active, created_at, updated_at FROM members"
+	var f models.MemberFilter
 	if activeOnly {
-		query += " WHERE active = 1"
+		f.Active = &activeOnly
+	}
+	return GetMembers(f)
+}
+
+// GetMembers returns the members matching f, ordered by name
+func GetMembers(f models.MemberFilter) ([]models.Member, error) {
+	query := "SELECT " + memberColumns + " FROM members WHERE 1 = 1"
+	var args []interface{}
+
+	if f.Active != nil {
+		query += " AND active = ?"
+		args = append(args, *f.Active)
+	}
+	if f.JoinedFrom != "" {
+		query += " AND date(created_at) >= date(?)"
+		args = append(args, f.JoinedFrom)
+	}
+	if f.JoinedTo != "" {
+		query += " AND date(created_at) <= date(?)"
+		args = append(args, f.JoinedTo)
 	}
 	query += " ORDER BY last_name, first_name"
 
-	rows, err := DB.Query(query)
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	values, err := GetAllMemberFieldValues()
+	if err != nil {
+		return nil, err
+	}
+
 	var members []models.Member
 	for rows.Next() {
-		var m models.Member
-		var email, phone sql.NullString
-		err := rows.Scan(&m.ID, &m.MembershipNumber, &m.FirstName, &m.LastName, &email, &phone, &m.Active, &m.CreatedAt, &m.UpdatedAt)
+		m, err := scanMember(rows)
 		if err != nil {
 			return nil, err
 		}
-		m.Email = email.String
-		m.Phone = phone.String
-		members = append(members, m)
+		m.CustomFields = values[m.ID]
+		members = append(members, *m)
 	}
 	return members, nil
 }
 
 func GetMemberByID(id int64) (*models.Member, error) {
-	var m models.Member
-	var email, phone sql.NullString
-	err := DB.QueryRow(
-		"SELECT id, membership_number, first_name, last_name, email, phone, active, created_at, updated_at FROM members WHERE id = ?",
-		id,
-	).Scan(&m.ID, &m.MembershipNumber, &m.FirstName, &m.LastName, &email, &phone, &m.Active, &m.CreatedAt, &m.UpdatedAt)
+	m, err := scanMember(DB.QueryRow("SELECT "+memberColumns+" FROM members WHERE id = ?", id))
 	if err != nil {
 		return nil, err
 	}
-	m.Email = email.String
-	m.Phone = phone.String
-	return &m, nil
+	m.CustomFields, err = GetMemberFieldValues(id)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
 func GetMemberByMembershipNumber(num string) (*models.Member, error) {
-	var m models.Member
-	var email, phone sql.NullString
-	err := DB.QueryRow(
-		"SELECT id, membership_number, first_name, last_name, email, phone, active, created_at, updated_at FROM members WHERE membership_number = ?",
-		num,
-	).Scan(&m.ID, &m.MembershipNumber, &m.FirstName, &m.LastName, &email, &phone, &m.Active, &m.CreatedAt, &m.UpdatedAt)
+	return scanMember(DB.QueryRow("SELECT "+memberColumns+" FROM members WHERE membership_number = ?", num))
+}
+
+func CreateMember(req models.CreateMemberRequest) (*models.Member, error) {
+	tx, err := DB.Begin()
 	if err != nil {
 		return nil, err
 	}
-	m.Email = email.String
-	m.Phone = phone.String
-	return &m, nil
-}
+	defer tx.Rollback()
 
-func CreateMember(req models.CreateMemberRequest) (*models.Member, error) {
-	result, err := DB.Exec(
+	result, err := tx.Exec(
 		"INSERT INTO members (membership_number, first_name, last_name, email, phone) VALUES (?, ?, ?, ?, ?)",
 		req.MembershipNumber, req.FirstName, req.LastName, req.Email, req.Phone,
 	)
@@ -191,6 +150,12 @@ func CreateMember(req models.CreateMemberRequest) (*models.Member, error) {
 		return nil, err
 	}
 	id, _ := result.LastInsertId()
+	if err := setMemberFieldValues(tx, id, req.CustomFields); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return GetMemberByID(id)
 }
 
@@ -219,13 +184,25 @@ func UpdateMember(id int64, req models.UpdateMemberRequest) (*models.Member, err
 		m.Active = *req.Active
 	}
 
-	_, err = DB.Exec(
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		"UPDATE members SET membership_number = ?, first_name = ?, last_name = ?, email = ?, phone = ?, active = ?, updated_at = ? WHERE id = ?",
 		m.MembershipNumber, m.FirstName, m.LastName, m.Email, m.Phone, m.Active, time.Now(), id,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := setMemberFieldValues(tx, id, req.CustomFields); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return GetMemberByID(id)
 }
 
@@ -351,49 +328,133 @@ func CloseHike(id int64) (*models.Hike, error) {
 	return GetHikeByID(id)
 }
 
-// Checkin operations
+// ErrHikeClosed is returned when changing attendance on a closed hike
+var ErrHikeClosed = errors.New("hike is closed")
 
-func CreateCheckin(hikeID int64, membershipNumber string) (*models.Checkin, error) {
-	member, err := GetMemberByMembershipNumber(membershipNumber)
+// ErrHikeNotClosed is returned when reopening a hike that is still open
+var ErrHikeNotClosed = errors.New("hike is not closed")
+
+// ensureHikeOpen returns ErrHikeClosed if the hike has been closed. Unknown
+// hikes are left for the foreign key constraints to reject.
+func ensureHikeOpen(hikeID int64) error {
+	var status string
+	err := DB.QueryRow("SELECT status FROM hikes WHERE id = ?", hikeID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status == "closed" {
+		return ErrHikeClosed
+	}
+	return nil
+}
+
+// ReopenHike reopens a closed hike and records who reopened it and why
+func ReopenHike(id int64, userID int64, reason string) (*models.Hike, error) {
+	tx, err := DB.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	result, err := DB.Exec(
-		"INSERT OR IGNORE INTO checkins (hike_id, member_id) VALUES (?, ?)",
-		hikeID, member.ID,
+	result, err := tx.Exec("UPDATE hikes SET status = 'open' WHERE id = ? AND status = 'closed'", id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrHikeNotClosed
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO hike_reopenings (hike_id, user_id, reason) VALUES (?, ?, ?)",
+		id, userID, reason,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	id, _ := result.LastInsertId()
-	if id == 0 {
-		// Already checked in, get existing
-		var c models.Checkin
-		err := DB.QueryRow(
-			"SELECT id, hike_id, member_id, checked_in_at, synced FROM checkins WHERE hike_id = ? AND member_id = ?",
-			hikeID, member.ID,
-		).Scan(&c.ID, &c.HikeID, &c.MemberID, &c.CheckedInAt, &c.Synced)
-		if err != nil {
-			return nil, err
-		}
-		c.MemberName = member.FirstName + " " + member.LastName
-		c.MembershipNumber = member.MembershipNumber
-		return &c, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
+	return GetHikeByID(id)
+}
 
-	var c models.Checkin
-	err = DB.QueryRow(
-		"SELECT id, hike_id, member_id, checked_in_at, synced FROM checkins WHERE id = ?",
-		id,
-	).Scan(&c.ID, &c.HikeID, &c.MemberID, &c.CheckedInAt, &c.Synced)
+// Checkin operations
+
+func CreateCheckin(hikeID int64, membershipNumber string) (*models.Checkin, error) {
+	checkin, _, err := createCheckin(hikeID, membershipNumber, nil, "")
+	return checkin, err
+}
+
+// CreateOfflineCheckin records a check-in uploaded from a device's offline
+// queue and reports whether a new row was created. When checkedInAt is set
+// the scan time is kept and the row stays unsynced (synced = 0) until
+// MarkCheckinsSynced is called once the whole batch has been accepted.
+func CreateOfflineCheckin(hikeID int64, membershipNumber string, checkedInAt *time.Time, deviceID string) (*models.Checkin, bool, error) {
+	return createCheckin(hikeID, membershipNumber, checkedInAt, deviceID)
+}
+
+func createCheckin(hikeID int64, membershipNumber string, checkedInAt *time.Time, deviceID string) (*models.Checkin, bool, error) {
+	member, err := GetMemberByMembershipNumber(membershipNumber)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	c.MemberName = member.FirstName + " " + member.LastName
-	c.MembershipNumber = member.MembershipNumber
-	return &c, nil
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return nil, false, err
+	}
+
+	var device sql.NullString
+	if deviceID != "" {
+		device = sql.NullString{String: deviceID, Valid: true}
+	}
+
+	var result sql.Result
+	if checkedInAt == nil {
+		result, err = DB.Exec(
+			"INSERT OR IGNORE INTO checkins (hike_id, member_id, device_id) VALUES (?, ?, ?)",
+			hikeID, member.ID, device,
+		)
+	} else {
+		// Match the CURRENT_TIMESTAMP format so ordering by checked_in_at stays correct
+		result, err = DB.Exec(
+			"INSERT OR IGNORE INTO checkins (hike_id, member_id, checked_in_at, synced, device_id) VALUES (?, ?, ?, 0, ?)",
+			hikeID, member.ID, checkedInAt.UTC().Format("2006-01-02 15:04:05"), device,
+		)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// If already checked in this returns the existing record
+	id, err := GetCheckinIDForMember(hikeID, member.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	checkin, err := GetCheckinByID(*id)
+	if err != nil {
+		return nil, false, err
+	}
+	return checkin, rows > 0, nil
+}
+
+// MarkCheckinsSynced flags offline check-ins as synced once a batch has been stored
+func MarkCheckinsSynced(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []interface{}{time.Now().UTC().Format("2006-01-02 15:04:05")}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := DB.Exec("UPDATE checkins SET synced = 1, synced_at = ? WHERE synced = 0 AND id IN ("+placeholders+")", args...)
+	return err
 }
 
 // DeleteCheckin removes a check-in for a member from a hike
@@ -411,6 +472,9 @@ func UndoRSVPCheckin(rsvpID int64) error {
 	if err != nil {
 		return err
 	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return err
+	}
 
 	if memberID.Valid {
 		// Member RSVP - delete the checkin record
@@ -425,10 +489,12 @@ func UndoRSVPCheckin(rsvpID int64) error {
 func GetCheckinsForHike(hikeID int64) ([]models.Checkin, error) {
 	rows, err := DB.Query(`
 		SELECT c.id, c.hike_id, c.member_id, c.checked_in_at, c.synced,
-		       c.is_leader, c.is_sweeper,
-		       m.first_name || ' ' || m.last_name as member_name, m.membership_number
+		       c.is_leader, c.is_sweeper, c.checked_out_at, c.checkout_method,
+		       m.first_name || ' ' || m.last_name as member_name, m.membership_number,
+		       `+lapsedOn("c.member_id", "h.date")+`
 		FROM checkins c
 		JOIN members m ON c.member_id = m.id
+		JOIN hikes h ON c.hike_id = h.id
 		WHERE c.hike_id = ?
 		ORDER BY c.checked_in_at DESC
 	`, hikeID)
@@ -440,15 +506,52 @@ func GetCheckinsForHike(hikeID int64) ([]models.Checkin, error) {
 	var checkins []models.Checkin
 	for rows.Next() {
 		var c models.Checkin
-		err := rows.Scan(&c.ID, &c.HikeID, &c.MemberID, &c.CheckedInAt, &c.Synced, &c.IsLeader, &c.IsSweeper, &c.MemberName, &c.MembershipNumber)
+		var checkedOutAt sql.NullTime
+		var checkoutMethod sql.NullString
+		err := rows.Scan(&c.ID, &c.HikeID, &c.MemberID, &c.CheckedInAt, &c.Synced, &c.IsLeader, &c.IsSweeper, &checkedOutAt, &checkoutMethod, &c.MemberName, &c.MembershipNumber, &c.Expired)
 		if err != nil {
 			return nil, fmt.Errorf("scan error at row: %w", err)
 		}
+		if checkedOutAt.Valid {
+			c.CheckedOutAt = &checkedOutAt.Time
+		}
+		c.CheckoutMethod = checkoutMethod.String
 		checkins = append(checkins, c)
 	}
 	return checkins, nil
 }
 
+// GetCheckinByID returns a single check-in with member details
+func GetCheckinByID(id int64) (*models.Checkin, error) {
+	var c models.Checkin
+	var deviceID, checkoutMethod sql.NullString
+	var syncedAt, checkedOutAt sql.NullTime
+	err := DB.QueryRow(`
+		SELECT c.id, c.hike_id, c.member_id, c.checked_in_at, c.synced,
+		       c.is_leader, c.is_sweeper, c.device_id, c.synced_at,
+		       c.checked_out_at, c.checkout_method,
+		       m.first_name || ' ' || m.last_name as member_name, m.membership_number,
+		       `+lapsedOn("c.member_id", "h.date")+`
+		FROM checkins c
+		JOIN members m ON c.member_id = m.id
+		JOIN hikes h ON c.hike_id = h.id
+		WHERE c.id = ?
+	`, id).Scan(&c.ID, &c.HikeID, &c.MemberID, &c.CheckedInAt, &c.Synced, &c.IsLeader, &c.IsSweeper, &deviceID, &syncedAt,
+		&checkedOutAt, &checkoutMethod, &c.MemberName, &c.MembershipNumber, &c.Expired)
+	if err != nil {
+		return nil, err
+	}
+	c.DeviceID = deviceID.String
+	if syncedAt.Valid {
+		c.SyncedAt = &syncedAt.Time
+	}
+	if checkedOutAt.Valid {
+		c.CheckedOutAt = &checkedOutAt.Time
+	}
+	c.CheckoutMethod = checkoutMethod.String
+	return &c, nil
+}
+
 // UpdateCheckinRole toggles a role for a check-in
 func UpdateCheckinRole(checkinID int64, role string, value bool) error {
 	column := ""
@@ -460,6 +563,14 @@ func UpdateCheckinRole(checkinID int64, role string, value bool) error {
 		return fmt.Errorf("invalid role: %s", role)
 	}
 
+	var hikeID int64
+	if err := DB.QueryRow("SELECT hike_id FROM checkins WHERE id = ?", checkinID).Scan(&hikeID); err != nil {
+		return err
+	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return err
+	}
+
 	val := 0
 	if value {
 		val = 1
@@ -530,7 +641,7 @@ func GetMemberAttendanceHistory(memberID int64) ([]models.Hike, error) {
 
 func GetAllAttendanceForYear(year string) ([]models.AttendanceRecord, error) {
 	rows, err := DB.Query(`
-		SELECT c.id, h.date, h.name, h.location, m.membership_number, m.first_name, m.last_name, c.checked_in_at
+		SELECT c.id, h.date, h.name, h.location, m.id, m.membership_number, m.first_name, m.last_name, c.checked_in_at
 		FROM checkins c
 		JOIN hikes h ON c.hike_id = h.id
 		JOIN members m ON c.member_id = m.id
@@ -546,7 +657,7 @@ func GetAllAttendanceForYear(year string) ([]models.AttendanceRecord, error) {
 	for rows.Next() {
 		var r models.AttendanceRecord
 		var location sql.NullString
-		err := rows.Scan(&r.CheckinID, &r.HikeDate, &r.HikeName, &location, &r.MembershipNumber, &r.FirstName, &r.LastName, &r.CheckedInAt)
+		err := rows.Scan(&r.CheckinID, &r.HikeDate, &r.HikeName, &location, &r.MemberID, &r.MembershipNumber, &r.FirstName, &r.LastName, &r.CheckedInAt)
 		if err != nil {
 			return nil, err
 		}
@@ -575,6 +686,13 @@ func CreateRSVPForGuest(hikeID int64, guestName string) error {
 }
 
 func DeleteRSVP(id int64) error {
+	var hikeID int64
+	if err := DB.QueryRow("SELECT hike_id FROM rsvps WHERE id = ?", id).Scan(&hikeID); err != nil {
+		return err
+	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return err
+	}
 	_, err := DB.Exec("DELETE FROM rsvps WHERE id = ?", id)
 	return err
 }
@@ -588,7 +706,12 @@ func GetRSVPsForHike(hikeID int64) ([]models.RSVP, error) {
 		           WHEN c.id IS NOT NULL THEN 1
 		           WHEN r.checked_in_at IS NOT NULL THEN 1
 		           ELSE 0
-		       END as checked_in
+		       END as checked_in,
+		       CASE
+		           WHEN c.checked_out_at IS NOT NULL THEN 1
+		           WHEN r.checked_out_at IS NOT NULL THEN 1
+		           ELSE 0
+		       END as checked_out
 		FROM rsvps r
 		LEFT JOIN members m ON r.member_id = m.id
 		LEFT JOIN checkins c ON r.hike_id = c.hike_id AND r.member_id = c.member_id
@@ -605,7 +728,7 @@ func GetRSVPsForHike(hikeID int64) ([]models.RSVP, error) {
 		var r models.RSVP
 		var memberID sql.NullInt64
 		var guestName, memberName, membershipNumber sql.NullString
-		err := rows.Scan(&r.ID, &r.HikeID, &memberID, &guestName, &r.CreatedAt, &memberName, &membershipNumber, &r.CheckedIn)
+		err := rows.Scan(&r.ID, &r.HikeID, &memberID, &guestName, &r.CreatedAt, &memberName, &membershipNumber, &r.CheckedIn, &r.CheckedOut)
 		if err != nil {
 			return nil, err
 		}
@@ -621,6 +744,43 @@ func GetRSVPsForHike(hikeID int64) ([]models.RSVP, error) {
 	return rsvps, nil
 }
 
+// GetRSVPByID returns a single RSVP with member details
+func GetRSVPByID(id int64) (*models.RSVP, error) {
+	var r models.RSVP
+	var memberID sql.NullInt64
+	var guestName, memberName, membershipNumber sql.NullString
+	err := DB.QueryRow(`
+		SELECT r.id, r.hike_id, r.member_id, r.guest_name, r.created_at,
+		       m.first_name || ' ' || m.last_name as member_name,
+		       m.membership_number,
+		       CASE
+		           WHEN c.id IS NOT NULL THEN 1
+		           WHEN r.checked_in_at IS NOT NULL THEN 1
+		           ELSE 0
+		       END as checked_in,
+		       CASE
+		           WHEN c.checked_out_at IS NOT NULL THEN 1
+		           WHEN r.checked_out_at IS NOT NULL THEN 1
+		           ELSE 0
+		       END as checked_out
+		FROM rsvps r
+		LEFT JOIN members m ON r.member_id = m.id
+		LEFT JOIN checkins c ON r.hike_id = c.hike_id AND r.member_id = c.member_id
+		WHERE r.id = ?
+	`, id).Scan(&r.ID, &r.HikeID, &memberID, &guestName, &r.CreatedAt, &memberName, &membershipNumber, &r.CheckedIn, &r.CheckedOut)
+	if err != nil {
+		return nil, err
+	}
+	if memberID.Valid {
+		r.MemberID = &memberID.Int64
+		r.MemberName = memberName.String
+		r.MembershipNumber = membershipNumber.String
+	} else {
+		r.GuestName = guestName.String
+	}
+	return &r, nil
+}
+
 // CheckInRSVP checks in an RSVP - for members it creates a checkin record, for guests it sets checked_in_at
 func CheckInRSVP(rsvpID int64) error {
 	// Get the RSVP details
@@ -630,6 +790,9 @@ func CheckInRSVP(rsvpID int64) error {
 	if err != nil {
 		return err
 	}
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return err
+	}
 
 	if memberID.Valid {
 		// Member RSVP - create a checkin record
@@ -730,6 +893,9 @@ func similarity(a, b string) int {
 // Activity operations
 
 func CreateActivity(hikeID int64, name string) (*models.Activity, error) {
+	if err := ensureHikeOpen(hikeID); err != nil {
+		return nil, err
+	}
 	result, err := DB.Exec(
 		"INSERT INTO activities (hike_id, name) VALUES (?, ?)",
 		hikeID, name,
@@ -780,12 +946,27 @@ func GetActivitiesForHike(hikeID int64) ([]models.Activity, error) {
 	return activities, nil
 }
 
+// ensureActivityHikeOpen returns ErrHikeClosed if the activity's hike is closed
+func ensureActivityHikeOpen(activityID int64) error {
+	var hikeID int64
+	if err := DB.QueryRow("SELECT hike_id FROM activities WHERE id = ?", activityID).Scan(&hikeID); err != nil {
+		return err
+	}
+	return ensureHikeOpen(hikeID)
+}
+
 func DeleteActivity(id int64) error {
+	if err := ensureActivityHikeOpen(id); err != nil {
+		return err
+	}
 	_, err := DB.Exec("DELETE FROM activities WHERE id = ?", id)
 	return err
 }
 
 func AddActivityParticipant(activityID int64, checkinID *int64, rsvpID *int64) error {
+	if err := ensureActivityHikeOpen(activityID); err != nil {
+		return err
+	}
 	_, err := DB.Exec(
 		"INSERT OR IGNORE INTO activity_participants (activity_id, checkin_id, rsvp_id) VALUES (?, ?, ?)",
 		activityID, checkinID, rsvpID,
@@ -794,6 +975,9 @@ func AddActivityParticipant(activityID int64, checkinID *int64, rsvpID *int64) e
 }
 
 func RemoveActivityParticipant(activityID int64, checkinID *int64, rsvpID *int64) error {
+	if err := ensureActivityHikeOpen(activityID); err != nil {
+		return err
+	}
 	if checkinID != nil {
 		_, err := DB.Exec("DELETE FROM activity_participants WHERE activity_id = ? AND checkin_id = ?", activityID, *checkinID)
 		return err
@@ -853,6 +1037,16 @@ func GetCheckinIDForMember(hikeID, memberID int64) (*int64, error) {
 	return &id, nil
 }
 
+// GetRSVPIDForMember returns the RSVP ID for a member in a hike
+func GetRSVPIDForMember(hikeID, memberID int64) (*int64, error) {
+	var id int64
+	err := DB.QueryRow("SELECT id FROM rsvps WHERE hike_id = ? AND member_id = ?", hikeID, memberID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
 // GetRSVPIDForGuest returns the RSVP ID for a guest in a hike
 func GetRSVPIDForGuest(hikeID int64, guestName string) (*int64, error) {
 	var id int64
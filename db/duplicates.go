@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"trailcall/models"
+)
+
+// Duplicate members
+//
+// Members entered twice, for example once by hand and again from an import,
+// are found by name similarity and shared contact details, and merged into
+// one surviving record.
+
+// phoneKey reduces a phone number to its last nine digits so local (082...)
+// and international (+27 82...) forms of the same number compare equal
+func phoneKey(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < 9 {
+		return string(digits)
+	}
+	return string(digits[len(digits)-9:])
+}
+
+// nameScore compares two members' names, allowing for first and last name
+// entered the wrong way round
+func nameScore(a, b *models.Member) int {
+	aFull := strings.ToLower(strings.TrimSpace(a.FirstName) + " " + strings.TrimSpace(a.LastName))
+	aSwapped := strings.ToLower(strings.TrimSpace(a.LastName) + " " + strings.TrimSpace(a.FirstName))
+	bFull := strings.ToLower(strings.TrimSpace(b.FirstName) + " " + strings.TrimSpace(b.LastName))
+
+	score := similarity(aFull, bFull)
+	if swapped := similarity(aSwapped, bFull); swapped > score {
+		score = swapped
+	}
+	return score
+}
+
+// FindDuplicateMembers lists pairs of members whose names score at least
+// minScore or who share an email address or phone number. Shared contact
+// details alone don't flag members of the same household, since families
+// often use one email address.
+func FindDuplicateMembers(activeOnly bool, minScore int) ([]models.DuplicateCandidate, error) {
+	members, err := GetAllMembers(activeOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.DuplicateCandidate
+	for i := range members {
+		a := &members[i]
+		for j := i + 1; j < len(members); j++ {
+			b := &members[j]
+
+			var reasons []string
+			score := nameScore(a, b)
+			if score >= minScore {
+				reasons = append(reasons, "name")
+			}
+			sameHousehold := a.HouseholdID != nil && b.HouseholdID != nil && *a.HouseholdID == *b.HouseholdID
+			if !sameHousehold || len(reasons) > 0 {
+				if a.Email != "" && strings.EqualFold(strings.TrimSpace(a.Email), strings.TrimSpace(b.Email)) {
+					reasons = append(reasons, "email")
+				}
+				if key := phoneKey(a.Phone); len(key) >= 9 && key == phoneKey(b.Phone) {
+					reasons = append(reasons, "phone")
+				}
+			}
+			if len(reasons) == 0 {
+				continue
+			}
+
+			candidates = append(candidates, models.DuplicateCandidate{
+				Member:    *a,
+				Match:     *b,
+				NameScore: score,
+				Reasons:   reasons,
+			})
+		}
+	}
+	return candidates, nil
+}
+
+// MergeMembers moves the duplicate's check-ins, RSVPs and activity
+// participation to the survivor in one transaction, then deactivates the
+// duplicate, invalidates its card and cancels its wallet links. Where both
+// have a check-in or RSVP for the same hike, the two are combined into the
+// survivor's. The survivor also takes any contact details, custom field
+// values, emergency details, household and non-overlapping membership periods
+// it doesn't already have.
+func MergeMembers(survivorID, duplicateID int64) (*models.MergeResult, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &models.MergeResult{}
+
+	result.CheckinsMoved, result.CheckinsMerged, err = mergeCheckins(tx, survivorID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	result.RSVPsMoved, result.RSVPsMerged, err = mergeRSVPs(tx, survivorID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Periods that would overlap the survivor's stay on the duplicate's record
+	moved, err := tx.Exec(`
+		UPDATE membership_periods SET member_id = ?
+		WHERE member_id = ? AND NOT EXISTS (
+			SELECT 1 FROM membership_periods s
+			WHERE s.member_id = ?
+			  AND (s.end_date IS NULL OR s.end_date >= membership_periods.start_date)
+			  AND (membership_periods.end_date IS NULL OR s.start_date <= membership_periods.end_date))
+	`, survivorID, duplicateID, survivorID)
+	if err != nil {
+		return nil, err
+	}
+	periods, _ := moved.RowsAffected()
+	result.PeriodsMoved = int(periods)
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO member_field_values (member_id, field_id, value)
+		SELECT ?, field_id, value FROM member_field_values WHERE member_id = ?
+	`, survivorID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mergeEmergencyInfo(tx, survivorID, duplicateID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE members SET
+			email = COALESCE(NULLIF(email, ''), (SELECT email FROM members WHERE id = ?)),
+			phone = COALESCE(NULLIF(phone, ''), (SELECT phone FROM members WHERE id = ?)),
+			household_id = COALESCE(household_id, (SELECT household_id FROM members WHERE id = ?)),
+			updated_at = ?
+		WHERE id = ?
+	`, duplicateID, duplicateID, duplicateID, time.Now(), survivorID)
+	if err != nil {
+		return nil, err
+	}
+	// The survivor takes over as primary contact only of the household it ends up in
+	_, err = tx.Exec(`
+		UPDATE households SET primary_member_id =
+			CASE WHEN id = (SELECT household_id FROM members WHERE id = ?) THEN ? ELSE NULL END
+		WHERE primary_member_id = ?
+	`, survivorID, survivorID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The duplicate's links would otherwise hand out the survivor's card
+	if err := deleteWalletLinks(tx, duplicateID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE members SET active = 0, household_id = NULL, card_version = card_version + 1, updated_at = ?
+		WHERE id = ?
+	`, time.Now(), duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result.Survivor, err = GetMemberByID(survivorID)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// hikeRecords returns the IDs of a member's rows in table (checkins or rsvps) by hike
+func hikeRecords(tx *sql.Tx, table string, memberID int64) (map[int64]int64, error) {
+	rows, err := tx.Query("SELECT hike_id, id FROM "+table+" WHERE member_id = ?", memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[int64]int64)
+	for rows.Next() {
+		var hikeID, id int64
+		if err := rows.Scan(&hikeID, &id); err != nil {
+			return nil, err
+		}
+		records[hikeID] = id
+	}
+	return records, rows.Err()
+}
+
+// mergeCheckins moves the duplicate's check-ins to the survivor. UNIQUE(hike_id,
+// member_id) allows one check-in per hike, so where the survivor already has
+// one the duplicate's is folded into it: the earlier check-in time, either
+// leader or sweeper flag, and its activities and incidents are kept.
+func mergeCheckins(tx *sql.Tx, survivorID, duplicateID int64) (moved, merged int, err error) {
+	survivor, err := hikeRecords(tx, "checkins", survivorID)
+	if err != nil {
+		return 0, 0, err
+	}
+	duplicate, err := hikeRecords(tx, "checkins", duplicateID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for hikeID, dupID := range duplicate {
+		keepID, ok := survivor[hikeID]
+		if !ok {
+			if _, err := tx.Exec("UPDATE checkins SET member_id = ? WHERE id = ?", survivorID, dupID); err != nil {
+				return 0, 0, err
+			}
+			moved++
+			continue
+		}
+
+		statements := []string{
+			`UPDATE checkins SET
+				checked_in_at = MIN(checkins.checked_in_at, d.checked_in_at),
+				is_leader = MAX(checkins.is_leader, d.is_leader),
+				is_sweeper = MAX(checkins.is_sweeper, d.is_sweeper),
+				checkout_method = CASE WHEN checkins.checked_out_at IS NULL THEN d.checkout_method ELSE checkins.checkout_method END,
+				checked_out_at = COALESCE(checkins.checked_out_at, d.checked_out_at)
+			FROM (SELECT * FROM checkins WHERE id = ?2) AS d
+			WHERE checkins.id = ?1`,
+			`INSERT OR IGNORE INTO activity_participants (activity_id, checkin_id, created_at)
+			SELECT activity_id, ?1, created_at FROM activity_participants WHERE checkin_id = ?2`,
+			`INSERT OR IGNORE INTO incident_people (incident_id, checkin_id)
+			SELECT incident_id, ?1 FROM incident_people WHERE checkin_id = ?2`,
+			`UPDATE checkin_idempotency SET checkin_id = ?1 WHERE checkin_id = ?2`,
+			`DELETE FROM activity_participants WHERE checkin_id = ?2`,
+			`DELETE FROM incident_people WHERE checkin_id = ?2`,
+			`DELETE FROM checkins WHERE id = ?2`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, keepID, dupID); err != nil {
+				return 0, 0, err
+			}
+		}
+		merged++
+	}
+	return moved, merged, nil
+}
+
+// mergeRSVPs moves the duplicate's RSVPs to the survivor, folding them into
+// the survivor's own RSVP for the same hike as mergeCheckins does
+func mergeRSVPs(tx *sql.Tx, survivorID, duplicateID int64) (moved, merged int, err error) {
+	survivor, err := hikeRecords(tx, "rsvps", survivorID)
+	if err != nil {
+		return 0, 0, err
+	}
+	duplicate, err := hikeRecords(tx, "rsvps", duplicateID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for hikeID, dupID := range duplicate {
+		keepID, ok := survivor[hikeID]
+		if !ok {
+			if _, err := tx.Exec("UPDATE rsvps SET member_id = ? WHERE id = ?", survivorID, dupID); err != nil {
+				return 0, 0, err
+			}
+			moved++
+			continue
+		}
+
+		statements := []string{
+			`UPDATE rsvps SET
+				created_at = MIN(rsvps.created_at, d.created_at),
+				checked_in_at = COALESCE(MIN(rsvps.checked_in_at, d.checked_in_at), rsvps.checked_in_at, d.checked_in_at),
+				checked_out_at = COALESCE(rsvps.checked_out_at, d.checked_out_at)
+			FROM (SELECT * FROM rsvps WHERE id = ?2) AS d
+			WHERE rsvps.id = ?1`,
+			`INSERT OR IGNORE INTO activity_participants (activity_id, rsvp_id, created_at)
+			SELECT activity_id, ?1, created_at FROM activity_participants WHERE rsvp_id = ?2`,
+			`DELETE FROM activity_participants WHERE rsvp_id = ?2`,
+			`DELETE FROM rsvps WHERE id = ?2`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, keepID, dupID); err != nil {
+				return 0, 0, err
+			}
+		}
+		merged++
+	}
+	return moved, merged, nil
+}
+
+// mergeEmergencyInfo gives the survivor the duplicate's emergency details if
+// it has none. The details are bound to the member ID, so they are decrypted
+// and sealed again for the survivor; if that isn't possible they stay where they are.
+func mergeEmergencyInfo(tx *sql.Tx, survivorID, duplicateID int64) error {
+	if !EncryptionEnabled() {
+		return nil
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM member_emergency_info WHERE member_id = ?)", survivorID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	var data string
+	err := tx.QueryRow("SELECT data FROM member_emergency_info WHERE member_id = ?", duplicateID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := decryptField(data, emergencyInfoAD(duplicateID))
+	if err != nil {
+		log.Printf("Failed to read emergency info for member %d, leaving it on the merged record: %v", duplicateID, err)
+		return nil
+	}
+	sealed, err := encryptField(plaintext, emergencyInfoAD(survivorID))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO member_emergency_info (member_id, data, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+		survivorID, sealed,
+	)
+	return err
+}
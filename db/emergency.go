@@ -0,0 +1,145 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"trailcall/models"
+)
+
+// Emergency info operations
+//
+// Next-of-kin and medical notes are kept as one encrypted JSON blob per
+// member, bound to the member ID so it can't be moved to another member.
+
+func emergencyInfoAD(memberID int64) string {
+	return "member_emergency_info:" + strconv.FormatInt(memberID, 10)
+}
+
+// SetEmergencyInfo replaces a member's emergency details; empty details remove them
+func SetEmergencyInfo(memberID int64, info models.EmergencyInfo) error {
+	if info == (models.EmergencyInfo{}) {
+		_, err := DB.Exec("DELETE FROM member_emergency_info WHERE member_id = ?", memberID)
+		return err
+	}
+
+	plaintext, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	data, err := encryptField(plaintext, emergencyInfoAD(memberID))
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		INSERT INTO member_emergency_info (member_id, data, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(member_id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at
+	`, memberID, data)
+	return err
+}
+
+// GetEmergencyInfo returns a member's decrypted emergency details, or nil if none are on file
+func GetEmergencyInfo(memberID int64) (*models.EmergencyInfo, error) {
+	var data string
+	err := DB.QueryRow("SELECT data FROM member_emergency_info WHERE member_id = ?", memberID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptField(data, emergencyInfoAD(memberID))
+	if err != nil {
+		return nil, err
+	}
+	var info models.EmergencyInfo
+	if err := json.Unmarshal(plaintext, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetEmergencyRoster lists everyone checked in to a hike with their
+// emergency details. Members whose details can't be decrypted are listed
+// without them rather than failing the whole roster.
+func GetEmergencyRoster(hikeID int64) (*models.EmergencyRoster, error) {
+	if !EncryptionEnabled() {
+		return nil, ErrNoEncryptionKey
+	}
+
+	hike, err := GetHikeByID(hikeID)
+	if err != nil {
+		return nil, err
+	}
+
+	roster := &models.EmergencyRoster{
+		HikeID:      hike.ID,
+		HikeName:    hike.Name,
+		HikeDate:    hike.Date,
+		Location:    hike.Location,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Entries:     []models.EmergencyRosterEntry{},
+	}
+
+	rows, err := DB.Query(`
+		SELECT m.id, m.first_name || ' ' || m.last_name, m.membership_number, m.phone,
+		       c.is_leader, c.is_sweeper
+		FROM checkins c
+		JOIN members m ON c.member_id = m.id
+		WHERE c.hike_id = ?
+		ORDER BY m.last_name, m.first_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var e models.EmergencyRosterEntry
+		var memberID int64
+		var phone sql.NullString
+		if err := rows.Scan(&memberID, &e.Name, &e.MembershipNumber, &phone, &e.IsLeader, &e.IsSweeper); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.MemberID = &memberID
+		e.Phone = phone.String
+		roster.Entries = append(roster.Entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range roster.Entries {
+		e := &roster.Entries[i]
+		info, err := GetEmergencyInfo(*e.MemberID)
+		if err != nil {
+			log.Printf("Failed to read emergency info for member %d: %v", *e.MemberID, err)
+			continue
+		}
+		e.EmergencyInfo = info
+	}
+
+	guestRows, err := DB.Query(`
+		SELECT guest_name FROM rsvps
+		WHERE hike_id = ? AND member_id IS NULL AND checked_in_at IS NOT NULL
+		ORDER BY guest_name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer guestRows.Close()
+
+	for guestRows.Next() {
+		e := models.EmergencyRosterEntry{IsGuest: true}
+		if err := guestRows.Scan(&e.Name); err != nil {
+			return nil, err
+		}
+		roster.Entries = append(roster.Entries, e)
+	}
+	return roster, guestRows.Err()
+}
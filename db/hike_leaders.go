@@ -0,0 +1,63 @@
+package db
+
+import (
+	"trailcall/models"
+)
+
+// Hike leader operations
+//
+// Leaders are the users assigned to run a hike. Apart from admins, only they
+// can read the hike's emergency roster.
+
+func GetHikeLeaders(hikeID int64) ([]models.User, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.name, u.username, u.role, u.active, u.created_at
+		FROM hike_leaders l
+		JOIN users u ON l.user_id = u.id
+		WHERE l.hike_id = ?
+		ORDER BY u.name
+	`, hikeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leaders []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Username, &u.Role, &u.Active, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		leaders = append(leaders, u)
+	}
+	return leaders, rows.Err()
+}
+
+// SetHikeLeaders replaces the users assigned to lead a hike
+func SetHikeLeaders(hikeID int64, userIDs []int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM hike_leaders WHERE hike_id = ?", hikeID); err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO hike_leaders (hike_id, user_id) VALUES (?, ?)", hikeID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// IsHikeLeader reports whether a user is assigned to lead a hike
+func IsHikeLeader(hikeID, userID int64) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM hike_leaders WHERE hike_id = ? AND user_id = ?)",
+		hikeID, userID,
+	).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"trailcall/models"
+)
+
+// Household operations
+//
+// A member belongs to at most one household, through members.household_id.
+
+// ErrHouseholdMember is returned when a household names a member that doesn't exist
+var ErrHouseholdMember = errors.New("household member not found")
+
+func scanHousehold(scanner interface{ Scan(...interface{}) error }) (*models.Household, error) {
+	var h models.Household
+	var primary sql.NullInt64
+	if err := scanner.Scan(&h.ID, &h.Name, &primary, &h.CreatedAt); err != nil {
+		return nil, err
+	}
+	if primary.Valid {
+		h.PrimaryMemberID = &primary.Int64
+	}
+	return &h, nil
+}
+
+func loadHouseholdMembers(h *models.Household) error {
+	rows, err := DB.Query("SELECT "+memberColumns+" FROM members WHERE household_id = ? ORDER BY first_name", h.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	h.Members = []models.Member{}
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return err
+		}
+		h.Members = append(h.Members, *m)
+	}
+	return rows.Err()
+}
+
+func GetAllHouseholds() ([]models.Household, error) {
+	rows, err := DB.Query("SELECT id, name, primary_member_id, created_at FROM households ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	var households []models.Household
+	for rows.Next() {
+		h, err := scanHousehold(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		households = append(households, *h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range households {
+		if err := loadHouseholdMembers(&households[i]); err != nil {
+			return nil, err
+		}
+	}
+	return households, nil
+}
+
+func GetHouseholdByID(id int64) (*models.Household, error) {
+	h, err := scanHousehold(DB.QueryRow("SELECT id, name, primary_member_id, created_at FROM households WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if err := loadHouseholdMembers(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// setHouseholdMembers replaces a household's members, moving any that were
+// in another household
+func setHouseholdMembers(tx *sql.Tx, householdID int64, memberIDs []int64) error {
+	if _, err := tx.Exec("UPDATE members SET household_id = NULL WHERE household_id = ?", householdID); err != nil {
+		return err
+	}
+	for _, id := range memberIDs {
+		result, err := tx.Exec("UPDATE members SET household_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", householdID, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %d", ErrHouseholdMember, id)
+		}
+		// A member moved out of another household stops being its primary contact
+		_, err = tx.Exec("UPDATE households SET primary_member_id = NULL WHERE primary_member_id = ? AND id != ?", id, householdID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateHousehold(req models.HouseholdRequest) (*models.Household, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO households (name, primary_member_id) VALUES (?, ?)", req.Name, req.PrimaryMemberID)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	if err := setHouseholdMembers(tx, id, req.MemberIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetHouseholdByID(id)
+}
+
+func UpdateHousehold(id int64, req models.HouseholdRequest) (*models.Household, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE households SET name = ?, primary_member_id = ? WHERE id = ?", req.Name, req.PrimaryMemberID, id); err != nil {
+		return nil, err
+	}
+	if err := setHouseholdMembers(tx, id, req.MemberIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetHouseholdByID(id)
+}
+
+// DeleteHousehold removes a household; its members stay, unlinked
+func DeleteHousehold(id int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE members SET household_id = NULL WHERE household_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM households WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MatchHouseholdMembers matches first names given on an RSVP against the
+// other active members of member's household. Names that match nobody are
+// returned separately.
+func MatchHouseholdMembers(member *models.Member, names []string) ([]models.Member, []string, error) {
+	var others []models.Member
+	if member.HouseholdID != nil {
+		h, err := GetHouseholdByID(*member.HouseholdID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range h.Members {
+			if m.ID != member.ID && m.Active {
+				others = append(others, m)
+			}
+		}
+	}
+
+	var matched []models.Member
+	var unmatched []string
+	taken := make(map[int64]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		lower := strings.ToLower(name)
+
+		var best *models.Member
+		bestScore := 0
+		for i := range others {
+			m := &others[i]
+			if taken[m.ID] {
+				continue
+			}
+			// Accept the first name alone or the full name
+			score := similarity(lower, strings.ToLower(m.FirstName))
+			if full := similarity(lower, strings.ToLower(m.FirstName+" "+m.LastName)); full > score {
+				score = full
+			}
+			if score > bestScore {
+				best, bestScore = m, score
+			}
+		}
+		if best == nil || bestScore < 80 {
+			unmatched = append(unmatched, name)
+			continue
+		}
+		taken[best.ID] = true
+		matched = append(matched, *best)
+	}
+	return matched, unmatched, nil
+}
+
+// GetHouseholdAttendanceForYear rolls up each household's check-ins in a year
+func GetHouseholdAttendanceForYear(year string) ([]models.HouseholdAttendance, error) {
+	rows, err := DB.Query(`
+		SELECT h.id, h.name,
+		       COALESCE((SELECT p.first_name || ' ' || p.last_name FROM members p WHERE p.id = h.primary_member_id), ''),
+		       (SELECT COUNT(*) FROM members m WHERE m.household_id = h.id),
+		       COUNT(DISTINCT hk.id), COUNT(c.id), COALESCE(MAX(hk.date), '')
+		FROM households h
+		LEFT JOIN members m ON m.household_id = h.id
+		LEFT JOIN checkins c ON c.member_id = m.id
+		     AND c.hike_id IN (SELECT id FROM hikes WHERE date LIKE ?)
+		LEFT JOIN hikes hk ON c.hike_id = hk.id
+		GROUP BY h.id
+		ORDER BY h.name
+	`, year+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.HouseholdAttendance
+	for rows.Next() {
+		var r models.HouseholdAttendance
+		err := rows.Scan(&r.HouseholdID, &r.Name, &r.PrimaryContact, &r.Members, &r.HikesAttended, &r.Checkins, &r.LastHike)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// Check-in idempotency operations
+//
+// Each check-in queued on a device carries a client-generated ID. Recording
+// it once the check-in is stored lets a retried upload recognise items that
+// already landed, even if the first response never reached the device.
+
+// GetCheckinForClientID looks up a previously seen client ID. found is false
+// for unseen IDs; checkinID is nil if the check-in has since been undone.
+func GetCheckinForClientID(clientID string) (checkinID *int64, found bool, err error) {
+	var id sql.NullInt64
+	err = DB.QueryRow("SELECT checkin_id FROM checkin_idempotency WHERE client_id = ?", clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if id.Valid {
+		checkinID = &id.Int64
+	}
+	return checkinID, true, nil
+}
+
+// SaveCheckinClientID records that clientID produced checkinID
+func SaveCheckinClientID(clientID string, checkinID int64) error {
+	_, err := DB.Exec(
+		"INSERT OR IGNORE INTO checkin_idempotency (client_id, checkin_id) VALUES (?, ?)",
+		clientID, checkinID,
+	)
+	return err
+}
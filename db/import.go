@@ -0,0 +1,59 @@
+package db
+
+import (
+	"time"
+
+	"trailcall/models"
+)
+
+// ApplyMemberImport saves a planned member import in one transaction, so a
+// failure part way through leaves the members as they were. It returns the
+// IDs of the created members in the order given.
+func ApplyMemberImport(create, update []models.ImportedMember, deactivate []int64) ([]int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var created []int64
+	for _, m := range create {
+		result, err := tx.Exec(
+			"INSERT INTO members (membership_number, first_name, last_name, email, phone, active) VALUES (?, ?, ?, ?, ?, ?)",
+			m.MembershipNumber, m.FirstName, m.LastName, m.Email, m.Phone, m.Active,
+		)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := result.LastInsertId()
+		if err := setMemberFieldValues(tx, id, m.CustomFields); err != nil {
+			return nil, err
+		}
+		created = append(created, id)
+	}
+
+	for _, m := range update {
+		_, err := tx.Exec(
+			"UPDATE members SET first_name = ?, last_name = ?, email = ?, phone = ?, active = ?, updated_at = ? WHERE id = ?",
+			m.FirstName, m.LastName, m.Email, m.Phone, m.Active, now, m.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := setMemberFieldValues(tx, m.ID, m.CustomFields); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range deactivate {
+		if _, err := tx.Exec("UPDATE members SET active = 0, updated_at = ? WHERE id = ?", now, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
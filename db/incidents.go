@@ -0,0 +1,278 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"trailcall/models"
+)
+
+// Incident operations
+
+// ErrIncidentPerson is returned when someone named on an incident is not
+// checked in to its hike
+var ErrIncidentPerson = errors.New("person is not checked in to this hike")
+
+// resolveIncidentPeople maps the check-in IDs and membership numbers in a
+// request to check-ins on the hike, dropping repeats
+func resolveIncidentPeople(tx *sql.Tx, hikeID int64, req models.IncidentRequest) ([]int64, error) {
+	seen := make(map[int64]bool)
+	var ids []int64
+	add := func(id int64) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range req.CheckinIDs {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM checkins WHERE id = ? AND hike_id = ?", id, hikeID).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: check-in %d", ErrIncidentPerson, id)
+		}
+		add(id)
+	}
+
+	for _, number := range req.MembershipNumbers {
+		var id int64
+		err := tx.QueryRow(`
+			SELECT c.id FROM checkins c
+			JOIN members m ON c.member_id = m.id
+			WHERE c.hike_id = ? AND m.membership_number = ?
+		`, hikeID, number).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrIncidentPerson, number)
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(id)
+	}
+	return ids, nil
+}
+
+func setIncidentPeople(tx *sql.Tx, incidentID int64, checkinIDs []int64) error {
+	if _, err := tx.Exec("DELETE FROM incident_people WHERE incident_id = ?", incidentID); err != nil {
+		return err
+	}
+	for _, id := range checkinIDs {
+		if _, err := tx.Exec("INSERT INTO incident_people (incident_id, checkin_id) VALUES (?, ?)", incidentID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// occurredAtValue stores an incident time in the same UTC format as CURRENT_TIMESTAMP
+func occurredAtValue(req models.IncidentRequest) interface{} {
+	if req.OccurredAt == nil {
+		return nil
+	}
+	return req.OccurredAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+// CreateIncident logs an incident against a hike. clientID and deviceID are
+// set when the incident was queued on a device while offline.
+func CreateIncident(hikeID int64, req models.IncidentRequest, reportedBy int64, deviceID string) (*models.Incident, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	people, err := resolveIncidentPeople(tx, hikeID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientID, device interface{}
+	if req.ClientID != "" {
+		clientID = req.ClientID
+	}
+	if deviceID != "" {
+		device = deviceID
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO incidents (hike_id, severity, description, actions_taken, reported_by, occurred_at, client_id, device_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, hikeID, req.Severity, req.Description, req.ActionsTaken, reportedBy, occurredAtValue(req), clientID, device)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+
+	if err := setIncidentPeople(tx, id, people); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetIncidentByID(id)
+}
+
+// UpdateIncident replaces an incident's details and the people involved
+func UpdateIncident(id int64, req models.IncidentRequest) (*models.Incident, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var hikeID int64
+	if err := tx.QueryRow("SELECT hike_id FROM incidents WHERE id = ?", id).Scan(&hikeID); err != nil {
+		return nil, err
+	}
+
+	people, err := resolveIncidentPeople(tx, hikeID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE incidents SET severity = ?, description = ?, actions_taken = ?, occurred_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, req.Severity, req.Description, req.ActionsTaken, occurredAtValue(req), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setIncidentPeople(tx, id, people); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetIncidentByID(id)
+}
+
+// DeleteIncident removes an incident and its list of people involved
+func DeleteIncident(id int64) error {
+	_, err := DB.Exec("DELETE FROM incidents WHERE id = ?", id)
+	return err
+}
+
+// GetIncidentIDForClientID looks up an incident already synced from a device
+func GetIncidentIDForClientID(clientID string) (int64, bool, error) {
+	var id int64
+	err := DB.QueryRow("SELECT id FROM incidents WHERE client_id = ?", clientID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+const incidentColumns = `
+	SELECT i.id, i.hike_id, i.severity, i.description, i.actions_taken,
+	       COALESCE(u.username, ''), i.occurred_at, i.device_id,
+	       i.created_at, i.updated_at, h.name, h.date
+	FROM incidents i
+	JOIN hikes h ON i.hike_id = h.id
+	LEFT JOIN users u ON i.reported_by = u.id
+`
+
+func scanIncident(row interface{ Scan(...interface{}) error }) (*models.Incident, error) {
+	var i models.Incident
+	var actionsTaken, deviceID sql.NullString
+	var occurredAt sql.NullTime
+	err := row.Scan(&i.ID, &i.HikeID, &i.Severity, &i.Description, &actionsTaken,
+		&i.ReportedBy, &occurredAt, &deviceID, &i.CreatedAt, &i.UpdatedAt, &i.HikeName, &i.HikeDate)
+	if err != nil {
+		return nil, err
+	}
+	i.ActionsTaken = actionsTaken.String
+	i.DeviceID = deviceID.String
+	if occurredAt.Valid {
+		i.OccurredAt = &occurredAt.Time
+	}
+	return &i, nil
+}
+
+// loadIncidentPeople fills in who was involved in each incident
+func loadIncidentPeople(incidents []models.Incident) error {
+	for n := range incidents {
+		rows, err := DB.Query(`
+			SELECT c.id, m.id, m.first_name || ' ' || m.last_name, m.membership_number
+			FROM incident_people p
+			JOIN checkins c ON p.checkin_id = c.id
+			JOIN members m ON c.member_id = m.id
+			WHERE p.incident_id = ?
+			ORDER BY m.last_name, m.first_name
+		`, incidents[n].ID)
+		if err != nil {
+			return err
+		}
+
+		people := []models.IncidentPerson{}
+		for rows.Next() {
+			var p models.IncidentPerson
+			if err := rows.Scan(&p.CheckinID, &p.MemberID, &p.Name, &p.MembershipNumber); err != nil {
+				rows.Close()
+				return err
+			}
+			people = append(people, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		incidents[n].People = people
+	}
+	return nil
+}
+
+func queryIncidents(query string, args ...interface{}) ([]models.Incident, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var incidents []models.Incident
+	for rows.Next() {
+		i, err := scanIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, *i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := loadIncidentPeople(incidents); err != nil {
+		return nil, err
+	}
+	return incidents, nil
+}
+
+// GetIncidentByID returns an incident with the people involved
+func GetIncidentByID(id int64) (*models.Incident, error) {
+	i, err := scanIncident(DB.QueryRow(incidentColumns+" WHERE i.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	incidents := []models.Incident{*i}
+	if err := loadIncidentPeople(incidents); err != nil {
+		return nil, err
+	}
+	return &incidents[0], nil
+}
+
+// GetIncidentsForHike lists a hike's incidents in the order they were logged
+func GetIncidentsForHike(hikeID int64) ([]models.Incident, error) {
+	return queryIncidents(incidentColumns+" WHERE i.hike_id = ? ORDER BY i.created_at, i.id", hikeID)
+}
+
+// GetIncidentsForYear lists every incident on hikes in a year, for the annual report
+func GetIncidentsForYear(year string) ([]models.Incident, error) {
+	return queryIncidents(incidentColumns+" WHERE h.date LIKE ? ORDER BY h.date, i.created_at, i.id", year+"%")
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"trailcall/models"
+)
+
+// Membership periods
+//
+// Each renewal adds a period rather than changing the member, so the
+// history of paid-up years is kept. Dates are stored as YYYY-MM-DD text, the
+// same as hike dates, so they compare directly.
+
+// ErrPeriodOverlap is returned when a new period overlaps an existing one
+var ErrPeriodOverlap = errors.New("membership period overlaps an existing one")
+
+// lapsedOn is an SQL expression that is true when the member in memberCol
+// has membership periods but none covering the date in dateCol. Members with
+// no recorded periods are never treated as lapsed, so clubs that don't track
+// renewals see no warnings.
+func lapsedOn(memberCol, dateCol string) string {
+	return fmt.Sprintf(`(EXISTS(SELECT 1 FROM membership_periods lp WHERE lp.member_id = %[1]s)
+		AND NOT EXISTS(SELECT 1 FROM membership_periods lp WHERE lp.member_id = %[1]s
+			AND lp.start_date <= %[2]s AND (lp.end_date IS NULL OR lp.end_date >= %[2]s)))`, memberCol, dateCol)
+}
+
+func scanMembershipPeriod(scanner interface{ Scan(...interface{}) error }) (*models.MembershipPeriod, error) {
+	var p models.MembershipPeriod
+	var endDate sql.NullString
+	err := scanner.Scan(&p.ID, &p.MemberID, &p.Type, &p.StartDate, &endDate, &p.CreatedBy, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.EndDate = endDate.String
+	return &p, nil
+}
+
+const membershipPeriodColumns = `p.id, p.member_id, p.type, p.start_date, p.end_date, COALESCE(u.username, ''), p.created_at`
+
+// GetMembershipPeriods returns a member's periods, latest first
+func GetMembershipPeriods(memberID int64) ([]models.MembershipPeriod, error) {
+	rows, err := DB.Query(`
+		SELECT `+membershipPeriodColumns+`
+		FROM membership_periods p
+		LEFT JOIN users u ON p.created_by = u.id
+		WHERE p.member_id = ?
+		ORDER BY p.start_date DESC, p.id DESC
+	`, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []models.MembershipPeriod
+	for rows.Next() {
+		p, err := scanMembershipPeriod(rows)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, *p)
+	}
+	return periods, rows.Err()
+}
+
+func GetMembershipPeriodByID(id int64) (*models.MembershipPeriod, error) {
+	return scanMembershipPeriod(DB.QueryRow(`
+		SELECT `+membershipPeriodColumns+`
+		FROM membership_periods p
+		LEFT JOIN users u ON p.created_by = u.id
+		WHERE p.id = ?
+	`, id))
+}
+
+// CreateMembershipPeriod records a period; endDate is empty for life memberships
+func CreateMembershipPeriod(memberID int64, periodType, startDate, endDate string, createdBy int64) (*models.MembershipPeriod, error) {
+	end := sql.NullString{String: endDate, Valid: endDate != ""}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Two periods overlap unless one ends before the other starts
+	var overlaps bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM membership_periods
+			WHERE member_id = ?
+			  AND (end_date IS NULL OR end_date >= ?)
+			  AND (? IS NULL OR start_date <= ?))
+	`, memberID, startDate, end, end).Scan(&overlaps)
+	if err != nil {
+		return nil, err
+	}
+	if overlaps {
+		return nil, ErrPeriodOverlap
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO membership_periods (member_id, type, start_date, end_date, created_by) VALUES (?, ?, ?, ?, ?)",
+		memberID, periodType, startDate, end, createdBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetMembershipPeriodByID(id)
+}
+
+// DeleteMembershipPeriod removes a period entered by mistake
+func DeleteMembershipPeriod(id int64) error {
+	_, err := DB.Exec("DELETE FROM membership_periods WHERE id = ?", id)
+	return err
+}
+
+// GetLapsedAttendanceForYear lists check-ins in a year by members whose
+// membership didn't cover the hike date
+func GetLapsedAttendanceForYear(year string) ([]models.LapsedAttendance, error) {
+	rows, err := DB.Query(`
+		SELECT h.id, h.date, h.name, m.id, m.membership_number, m.first_name, m.last_name, m.email,
+		       (SELECT MAX(p.end_date) FROM membership_periods p WHERE p.member_id = m.id AND p.end_date < h.date)
+		FROM checkins c
+		JOIN hikes h ON c.hike_id = h.id
+		JOIN members m ON c.member_id = m.id
+		WHERE h.date LIKE ? AND `+lapsedOn("m.id", "h.date")+`
+		ORDER BY m.last_name, m.first_name, h.date
+	`, year+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.LapsedAttendance
+	for rows.Next() {
+		var r models.LapsedAttendance
+		var email, lapsedSince sql.NullString
+		err := rows.Scan(&r.HikeID, &r.HikeDate, &r.HikeName, &r.MemberID, &r.MembershipNumber, &r.FirstName, &r.LastName, &email, &lapsedSince)
+		if err != nil {
+			return nil, err
+		}
+		r.Email = email.String
+		r.LapsedSince = lapsedSince.String
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,480 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one numbered schema change. Migrations are applied in order,
+// each inside its own transaction, and recorded in schema_migrations.
+//
+// Never edit a migration once released; add a new one instead. Steps use
+// IF NOT EXISTS and addColumn so databases created before versioning was
+// introduced can be brought under it without errors.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// MigrationStatus describes whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+var migrations = []migration{
+	{1, "initial schema", execSQL(`
+		CREATE TABLE IF NOT EXISTS members (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			membership_number TEXT UNIQUE NOT NULL,
+			first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL,
+			email TEXT,
+			phone TEXT,
+			active INTEGER DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS hikes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			date TEXT NOT NULL,
+			location TEXT,
+			notes TEXT,
+			status TEXT DEFAULT 'open',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS checkins (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			member_id INTEGER NOT NULL,
+			checked_in_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			synced INTEGER DEFAULT 1,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (member_id) REFERENCES members(id),
+			UNIQUE(hike_id, member_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS rsvps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			member_id INTEGER,
+			guest_name TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (member_id) REFERENCES members(id),
+			UNIQUE(hike_id, member_id),
+			UNIQUE(hike_id, guest_name)
+		);
+
+		CREATE TABLE IF NOT EXISTS activities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS activity_participants (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			activity_id INTEGER NOT NULL,
+			checkin_id INTEGER,
+			rsvp_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (activity_id) REFERENCES activities(id) ON DELETE CASCADE,
+			FOREIGN KEY (checkin_id) REFERENCES checkins(id) ON DELETE CASCADE,
+			FOREIGN KEY (rsvp_id) REFERENCES rsvps(id) ON DELETE CASCADE,
+			UNIQUE(activity_id, checkin_id),
+			UNIQUE(activity_id, rsvp_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_members_membership_number ON members(membership_number);
+		CREATE INDEX IF NOT EXISTS idx_checkins_hike_id ON checkins(hike_id);
+		CREATE INDEX IF NOT EXISTS idx_checkins_member_id ON checkins(member_id);
+		CREATE INDEX IF NOT EXISTS idx_rsvps_hike_id ON rsvps(hike_id);
+		CREATE INDEX IF NOT EXISTS idx_activities_hike_id ON activities(hike_id);
+		CREATE INDEX IF NOT EXISTS idx_activity_participants_activity_id ON activity_participants(activity_id);
+	`)},
+	{2, "hike rsvp_open flag", addColumn("hikes", "rsvp_open", "INTEGER DEFAULT 1")},
+	{3, "guest rsvp check-in time", addColumn("rsvps", "checked_in_at", "DATETIME")},
+	{4, "check-in leader and sweeper roles", func(tx *sql.Tx) error {
+		if err := addColumn("checkins", "is_leader", "INTEGER DEFAULT 0")(tx); err != nil {
+			return err
+		}
+		return addColumn("checkins", "is_sweeper", "INTEGER DEFAULT 0")(tx)
+	}},
+	{5, "users", execSQL(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			username TEXT UNIQUE NOT NULL COLLATE NOCASE,
+			pin_hash TEXT NOT NULL,
+			active INTEGER DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+	`)},
+	{6, "user roles", addColumn("users", "role", "TEXT DEFAULT 'leader'")},
+	{7, "sessions", execSQL(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+	`)},
+	{8, "audit log", execSQL(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER,
+			before_json TEXT,
+			after_json TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity_type, entity_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+	`)},
+	{9, "api tokens", execSQL(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			read_only INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+	`)},
+	{10, "offline check-in device and sync time", func(tx *sql.Tx) error {
+		if err := addColumn("checkins", "device_id", "TEXT")(tx); err != nil {
+			return err
+		}
+		return addColumn("checkins", "synced_at", "DATETIME")(tx)
+	}},
+	{11, "check-in idempotency keys", execSQL(`
+		CREATE TABLE IF NOT EXISTS checkin_idempotency (
+			client_id TEXT PRIMARY KEY,
+			checkin_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (checkin_id) REFERENCES checkins(id) ON DELETE SET NULL
+		);
+	`)},
+	{12, "hike reopenings", execSQL(`
+		CREATE TABLE IF NOT EXISTS hike_reopenings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			user_id INTEGER,
+			reason TEXT NOT NULL,
+			reopened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_hike_reopenings_hike_id ON hike_reopenings(hike_id);
+	`)},
+	{13, "end-of-hike checkout", func(tx *sql.Tx) error {
+		if err := addColumn("checkins", "checked_out_at", "DATETIME")(tx); err != nil {
+			return err
+		}
+		if err := addColumn("checkins", "checkout_method", "TEXT")(tx); err != nil {
+			return err
+		}
+		return addColumn("rsvps", "checked_out_at", "DATETIME")(tx)
+	}},
+	{14, "hike alerts", execSQL(`
+		CREATE TABLE IF NOT EXISTS hike_alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			notes TEXT,
+			sheet_json TEXT,
+			raised_by INTEGER,
+			raised_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resolved_by INTEGER,
+			resolved_at DATETIME,
+			resolution_notes TEXT,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (raised_by) REFERENCES users(id),
+			FOREIGN KEY (resolved_by) REFERENCES users(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_hike_alerts_hike_id ON hike_alerts(hike_id);
+	`)},
+	{15, "member emergency info", execSQL(`
+		CREATE TABLE IF NOT EXISTS member_emergency_info (
+			member_id INTEGER PRIMARY KEY,
+			data TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (member_id) REFERENCES members(id)
+		);
+	`)},
+	{16, "incidents", execSQL(`
+		CREATE TABLE IF NOT EXISTS incidents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hike_id INTEGER NOT NULL,
+			severity TEXT NOT NULL,
+			description TEXT NOT NULL,
+			actions_taken TEXT,
+			reported_by INTEGER,
+			occurred_at DATETIME,
+			client_id TEXT UNIQUE,
+			device_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (reported_by) REFERENCES users(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS incident_people (
+			incident_id INTEGER NOT NULL,
+			checkin_id INTEGER NOT NULL,
+			PRIMARY KEY (incident_id, checkin_id),
+			FOREIGN KEY (incident_id) REFERENCES incidents(id) ON DELETE CASCADE,
+			FOREIGN KEY (checkin_id) REFERENCES checkins(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_incidents_hike_id ON incidents(hike_id);
+	`)},
+	{17, "member card versions", addColumn("members", "card_version", "INTEGER NOT NULL DEFAULT 1")},
+	{18, "wallet links", execSQL(`
+		CREATE TABLE IF NOT EXISTS wallet_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			member_id INTEGER NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (member_id) REFERENCES members(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		);
+	`)},
+	{19, "membership periods", execSQL(`
+		CREATE TABLE IF NOT EXISTS membership_periods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			member_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			start_date TEXT NOT NULL,
+			end_date TEXT,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (member_id) REFERENCES members(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_membership_periods_member ON membership_periods(member_id, start_date);
+	`)},
+	{20, "households", func(tx *sql.Tx) error {
+		err := execSQL(`
+			CREATE TABLE IF NOT EXISTS households (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				primary_member_id INTEGER,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (primary_member_id) REFERENCES members(id)
+			);
+		`)(tx)
+		if err != nil {
+			return err
+		}
+		return addColumn("members", "household_id", "INTEGER REFERENCES households(id)")(tx)
+	}},
+	{21, "member custom fields", execSQL(`
+		CREATE TABLE IF NOT EXISTS custom_fields (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT UNIQUE NOT NULL,
+			label TEXT NOT NULL,
+			type TEXT NOT NULL,
+			required INTEGER NOT NULL DEFAULT 0,
+			options TEXT,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS member_field_values (
+			member_id INTEGER NOT NULL,
+			field_id INTEGER NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (member_id, field_id),
+			FOREIGN KEY (member_id) REFERENCES members(id),
+			FOREIGN KEY (field_id) REFERENCES custom_fields(id)
+		);
+	`)},
+	{22, "hike leaders", execSQL(`
+		CREATE TABLE IF NOT EXISTS hike_leaders (
+			hike_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (hike_id, user_id),
+			FOREIGN KEY (hike_id) REFERENCES hikes(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	`)},
+}
+
+// execSQL returns a migration step that runs a block of statements
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// addColumn returns a migration step that adds a column unless it already
+// exists, so genuine ALTER TABLE failures are no longer hidden
+func addColumn(table, column, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := columnExists(tx, table, column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+		return err
+	}
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func ensureMigrationsTable() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// appliedMigrations returns applied versions and when they were applied
+func appliedMigrations() (map[int]time.Time, error) {
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func migrate() error {
+	if err := ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
+	}
+
+	log.Printf("Database schema at version %d", LatestVersion())
+	return nil
+}
+
+func applyMigration(m migration) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LatestVersion is the schema version this build of TrailCall expects
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// GetMigrationStatus reports every known migration and whether it has been
+// applied, without changing the database
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	applied := make(map[int]time.Time)
+
+	var exists int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		applied, err = appliedMigrations()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"time"
+)
+
+// Session operations
+//
+// Sessions are keyed by a hash of the cookie token so a leaked database
+// doesn't hand out working logins. Times are stored in UTC so expiry
+// comparisons in SQL line up.
+
+func CreateSession(tokenHash string, userID int64, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		"INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userID, expiresAt.UTC(),
+	)
+	return err
+}
+
+// GetSession returns the user and expiry for a session token hash
+func GetSession(tokenHash string) (int64, time.Time, error) {
+	var userID int64
+	var expiresAt time.Time
+	err := DB.QueryRow(
+		"SELECT user_id, expires_at FROM sessions WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&userID, &expiresAt)
+	return userID, expiresAt, err
+}
+
+func DeleteSession(tokenHash string) error {
+	_, err := DB.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteSessionsForUser signs a user out of every device
+func DeleteSessionsForUser(userID int64) (int64, error) {
+	result, err := DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredSessions removes sessions past their expiry and returns how many were removed
+func DeleteExpiredSessions() (int64, error) {
+	result, err := DB.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
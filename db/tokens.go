@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"trailcall/models"
+)
+
+// API token operations
+//
+// Like sessions, only a hash of each token is stored.
+
+const apiTokenColumns = `t.id, t.user_id, t.name, t.read_only, t.created_at, t.last_used_at, t.revoked_at, u.username`
+
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+	var t models.APIToken
+	var lastUsed, revoked sql.NullTime
+	err := scanner.Scan(&t.ID, &t.UserID, &t.Name, &t.ReadOnly, &t.CreatedAt, &lastUsed, &revoked, &t.Username)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
+
+func CreateAPIToken(userID int64, name, tokenHash string, readOnly bool) (*models.APIToken, error) {
+	result, err := DB.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, read_only) VALUES (?, ?, ?, ?)",
+		userID, name, tokenHash, readOnly,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetAPITokenByID(id)
+}
+
+func GetAPITokenByID(id int64) (*models.APIToken, error) {
+	row := DB.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON t.user_id = u.id WHERE t.id = ?`, id)
+	return scanAPIToken(row)
+}
+
+// GetActiveAPIToken looks up an unrevoked token by its hash
+func GetActiveAPIToken(tokenHash string) (*models.APIToken, error) {
+	row := DB.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON t.user_id = u.id WHERE t.token_hash = ? AND t.revoked_at IS NULL`, tokenHash)
+	return scanAPIToken(row)
+}
+
+// GetAPITokens lists tokens for one user, or for everyone if userID is 0
+func GetAPITokens(userID int64) ([]models.APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens t JOIN users u ON t.user_id = u.id`
+	var args []interface{}
+	if userID != 0 {
+		query += " WHERE t.user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY t.revoked_at IS NOT NULL, t.created_at DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, nil
+}
+
+func TouchAPIToken(id int64) error {
+	_, err := DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return err
+}
+
+func RevokeAPIToken(id int64) error {
+	_, err := DB.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id)
+	return err
+}
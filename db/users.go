@@ -0,0 +1,68 @@
+package db
+
+import (
+	"strings"
+
+	"trailcall/models"
+)
+
+// User operations
+
+func GetUserByID(id int64) (*models.User, error) {
+	var u models.User
+	err := DB.QueryRow(
+		"SELECT id, name, username, pin_hash, role, active, created_at FROM users WHERE id = ?",
+		id,
+	).Scan(&u.ID, &u.Name, &u.Username, &u.PINHash, &u.Role, &u.Active, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func GetUserByUsername(username string) (*models.User, error) {
+	var u models.User
+	err := DB.QueryRow(
+		"SELECT id, name, username, pin_hash, role, active, created_at FROM users WHERE username = ?",
+		strings.TrimSpace(username),
+	).Scan(&u.ID, &u.Name, &u.Username, &u.PINHash, &u.Role, &u.Active, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func CreateUser(name, username, pinHash, role string) (*models.User, error) {
+	result, err := DB.Exec(
+		"INSERT INTO users (name, username, pin_hash, role) VALUES (?, ?, ?, ?)",
+		name, strings.ToLower(strings.TrimSpace(username)), pinHash, role,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return GetUserByID(id)
+}
+
+// SetUserActive enables or disables a user account by username
+func SetUserActive(username string, active bool) error {
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("UPDATE users SET active = ? WHERE id = ?", active, user.ID)
+	if err != nil {
+		return err
+	}
+	if !active {
+		_, err = DeleteSessionsForUser(user.ID)
+	}
+	return err
+}
+
+// CountActiveUsers returns how many users are able to log in
+func CountActiveUsers() (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE active = 1").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"trailcall/models"
+)
+
+// Wallet link operations
+//
+// A wallet link lets a member download their own wallet pass without
+// logging in. Like API tokens, only a hash of the link's token is stored.
+
+// CreateWalletLink stores a self-service link for a member
+func CreateWalletLink(memberID int64, tokenHash string, createdBy int64, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		"INSERT INTO wallet_links (member_id, token_hash, created_by, expires_at) VALUES (?, ?, ?, ?)",
+		memberID, tokenHash, createdBy, expiresAt.UTC().Format("2006-01-02 15:04:05"),
+	)
+	return err
+}
+
+// GetWalletLinkMember returns the active member an unexpired link belongs to
+func GetWalletLinkMember(tokenHash string) (*models.Member, error) {
+	return scanMember(DB.QueryRow(`
+		SELECT `+memberColumns+` FROM members
+		WHERE active = 1 AND id = (
+			SELECT member_id FROM wallet_links WHERE token_hash = ? AND expires_at > ?
+		)
+	`, tokenHash, time.Now().UTC().Format("2006-01-02 15:04:05")))
+}
+
+// DeleteWalletLinks cancels every self-service link for a member and returns
+// how many there were
+func DeleteWalletLinks(memberID int64) (int64, error) {
+	result, err := DB.Exec("DELETE FROM wallet_links WHERE member_id = ?", memberID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// deleteWalletLinks cancels a member's links as part of a larger change
+func deleteWalletLinks(tx *sql.Tx, memberID int64) error {
+	_, err := tx.Exec("DELETE FROM wallet_links WHERE member_id = ?", memberID)
+	return err
+}
@@ -29,21 +29,28 @@ const API = {
         const json = await response.json();
 
         if (!response.ok) {
-            throw new Error(json.error || response.statusText);
+            const err = new Error(json.error || response.statusText);
+            err.status = response.status;
+            err.data = json;
+            throw err;
         }
 
         return json;
     },
 
     // Auth
-    async login(pin) {
-        return this.request('POST', '/auth', { pin });
+    async login(username, pin) {
+        return this.request('POST', '/auth', { username, pin });
     },
 
     async logout() {
         return this.request('POST', '/auth/logout');
     },
 
+    async logoutAll() {
+        return this.request('POST', '/auth/logout-all');
+    },
+
     async checkAuth() {
         return this.request('GET', '/auth/check');
     },
@@ -70,13 +77,106 @@ const API = {
         return this.request('DELETE', `/members/${id}`);
     },
 
-    getMemberQRUrl(id) {
-        return `/api/members/${id}/qr`;
+    async setEmergencyInfo(id, info) {
+        return this.request('PUT', `/members/${id}/emergency`, info);
+    },
+
+    getMemberQRUrl(id, cardVersion) {
+        // The version busts the image cache once a card is revoked
+        return cardVersion ? `/api/members/${id}/qr?v=${cardVersion}` : `/api/members/${id}/qr`;
+    },
+
+    // Code in another format, e.g. { format: 'code128', output: 'svg' }
+    getMemberCodeUrl(id, options = {}) {
+        return `/api/members/${id}/qr?${new URLSearchParams(options)}`;
+    },
+
+    // Printable A4 sheet of cards; all active members when ids is empty
+    getMemberCardsPDFUrl(ids = []) {
+        return ids.length ? `/api/members/cards.pdf?ids=${ids.join(',')}` : '/api/members/cards.pdf';
+    },
+
+    async getDuplicates() {
+        return this.request('GET', '/members/duplicates');
+    },
+
+    getDuplicatesCSVUrl() {
+        return '/api/reports/duplicates';
+    },
+
+    // Moves the duplicate's attendance to the member and deactivates the duplicate
+    async mergeMembers(id, duplicateId) {
+        return this.request('POST', `/members/${id}/merge`, { duplicate_id: duplicateId });
+    },
+
+    async getMemberships(id) {
+        return this.request('GET', `/members/${id}/memberships`);
+    },
+
+    // With no details the member's last membership type is renewed for a year
+    async renewMembership(id, period = {}) {
+        return this.request('POST', `/members/${id}/memberships`, period);
+    },
+
+    async deleteMembershipPeriod(id, periodId) {
+        return this.request('DELETE', `/members/${id}/memberships/${periodId}`);
+    },
+
+    async revokeCard(id) {
+        return this.request('POST', `/members/${id}/revoke-card`);
+    },
+
+    getAppleWalletUrl(id) {
+        return `/api/members/${id}/wallet/apple`;
+    },
+
+    async getGoogleWalletPass(id) {
+        return this.request('GET', `/members/${id}/wallet/google`);
+    },
+
+    // A link the member can use to add their card to a wallet without logging in
+    async createWalletLink(id) {
+        return this.request('POST', `/members/${id}/wallet-link`);
+    },
+
+    async deleteWalletLinks(id) {
+        return this.request('DELETE', `/members/${id}/wallet-link`);
+    },
+
+    // Custom member fields defined by the club
+    async getCustomFields() {
+        return this.request('GET', '/custom-fields');
+    },
+
+    // Households
+    async getHouseholds() {
+        return this.request('GET', '/households');
+    },
+
+    async getHousehold(id) {
+        return this.request('GET', `/households/${id}`);
+    },
+
+    async createHousehold(data) {
+        return this.request('POST', '/households', data);
     },
 
-    async importMembersCSV(file) {
+    // member_ids is the full membership; anyone left out is removed
+    async updateHousehold(id, data) {
+        return this.request('PUT', `/households/${id}`, data);
+    },
+
+    async deleteHousehold(id) {
+        return this.request('DELETE', `/households/${id}`);
+    },
+
+    // mode is insert, upsert or sync; dryRun returns the planned changes
+    // without saving them
+    async importMembersCSV(file, mode = 'insert', dryRun = false) {
         const formData = new FormData();
         formData.append('file', file);
+        formData.append('mode', mode);
+        formData.append('dry_run', dryRun ? 'true' : 'false');
 
         const response = await fetch('/api/members/import', {
             method: 'POST',
@@ -89,6 +189,11 @@ const API = {
             throw new Error('Unauthorized');
         }
 
+        if (!response.ok) {
+            const text = await response.text();
+            throw new Error(text || response.statusText);
+        }
+
         return response.json();
     },
 
@@ -113,8 +218,76 @@ const API = {
         return this.request('PUT', `/hikes/${id}`, data);
     },
 
-    async closeHike(id) {
-        return this.request('POST', `/hikes/${id}/close`);
+    async closeHike(id, override = false, reason = '') {
+        return this.request('POST', `/hikes/${id}/close`, override ? { override, reason } : null);
+    },
+
+    async getHeadcount(id) {
+        return this.request('GET', `/hikes/${id}/headcount`);
+    },
+
+    // Alerts
+    async raiseAlert(hikeId, notes) {
+        return this.request('POST', `/hikes/${hikeId}/alerts`, { notes });
+    },
+
+    async getHikeAlerts(hikeId, status = '') {
+        return this.request('GET', `/hikes/${hikeId}/alerts${status ? '?status=' + status : ''}`);
+    },
+
+    async getAlert(id) {
+        return this.request('GET', `/alerts/${id}`);
+    },
+
+    async resolveAlert(id, notes) {
+        return this.request('POST', `/alerts/${id}/resolve`, { notes });
+    },
+
+    getAlertCSVUrl(id) {
+        return `/api/alerts/${id}?format=csv`;
+    },
+
+    async getHikeLeaders(hikeId) {
+        return this.request('GET', `/hikes/${hikeId}/leaders`);
+    },
+
+    async setHikeLeaders(hikeId, usernames) {
+        return this.request('PUT', `/hikes/${hikeId}/leaders`, { usernames });
+    },
+
+    getEmergencyRosterUrl(id) {
+        return `/api/hikes/${id}/emergency-roster?format=html`;
+    },
+
+    // Incidents
+    async getIncidents(hikeId) {
+        return this.request('GET', `/hikes/${hikeId}/incidents`);
+    },
+
+    async createIncident(hikeId, incident) {
+        return this.request('POST', `/hikes/${hikeId}/incidents`, incident);
+    },
+
+    getIncidentsCSVUrl(year) {
+        return `/api/reports/incidents?year=${year}`;
+    },
+
+    getLapsedCSVUrl(year) {
+        return `/api/reports/lapsed?year=${year}`;
+    },
+
+    getHouseholdsCSVUrl(year) {
+        return `/api/reports/households?year=${year}`;
+    },
+
+    // format is csv, json or vcf; filters are status, joined_from and joined_to
+    getMembersExportUrl(format = 'csv', filters = {}) {
+        const query = new URLSearchParams({ ...filters, format }).toString();
+        return `/api/reports/members?${query}`;
+    },
+
+    async reopenHike(id, reason) {
+        return this.request('POST', `/hikes/${id}/reopen`, { reason });
     },
 
     async getHikeCheckins(id) {
@@ -129,8 +302,33 @@ const API = {
         });
     },
 
-    async bulkCheckin(checkins) {
-        return this.request('POST', '/checkins/bulk', { checkins });
+    // Scanning one family card checks in the selected members of the household
+    async householdCheckin(hikeId, membershipNumber, memberIds) {
+        return this.request('POST', '/checkins/household', {
+            hike_id: hikeId,
+            membership_number: membershipNumber,
+            member_ids: memberIds,
+        });
+    },
+
+    async bulkCheckin(checkins, deviceId, incidents = []) {
+        return this.request('POST', '/checkins/bulk', { checkins, incidents, device_id: deviceId });
+    },
+
+    async checkoutMember(hikeId, membershipNumber) {
+        return this.request('POST', '/checkins/checkout', { hike_id: hikeId, membership_number: membershipNumber });
+    },
+
+    async checkoutCheckin(checkinId) {
+        return this.request('POST', `/checkins/${checkinId}/checkout`);
+    },
+
+    async undoCheckout(checkinId) {
+        return this.request('DELETE', `/checkins/${checkinId}/checkout`);
+    },
+
+    async checkoutRSVP(rsvpId) {
+        return this.request('POST', `/rsvps/${rsvpId}/checkout`);
     },
 
     async updateCheckinRole(checkinId, role, value) {
@@ -159,6 +357,30 @@ const API = {
         return `/api/reports/attendance?year=${y}`;
     },
 
+    // API tokens
+    async getAPITokens() {
+        return this.request('GET', '/tokens');
+    },
+
+    async createAPIToken(name, readOnly = false) {
+        return this.request('POST', '/tokens', { name, read_only: readOnly });
+    },
+
+    async revokeAPIToken(id) {
+        return this.request('DELETE', `/tokens/${id}`);
+    },
+
+    // Audit log
+    async getAuditLog(filters = {}) {
+        const query = new URLSearchParams(filters).toString();
+        return this.request('GET', `/audit${query ? '?' + query : ''}`);
+    },
+
+    getAuditCSVUrl(filters = {}) {
+        const query = new URLSearchParams({ ...filters, format: 'csv' }).toString();
+        return `/api/audit?${query}`;
+    },
+
     // RSVPs
     async getHikeRSVPs(hikeId) {
         return this.request('GET', `/hikes/${hikeId}/rsvps`);
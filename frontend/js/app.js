@@ -5,6 +5,8 @@ const App = {
     currentHike: null,
     members: [],
     isAuthenticated: false,
+    user: null,
+    scanMode: 'checkin',
     audioCtx: null,
 
     async init() {
@@ -23,6 +25,7 @@ const App = {
         try {
             const auth = await API.checkAuth();
             this.isAuthenticated = auth.authenticated;
+            this.user = auth.user || null;
         } catch (e) {
             this.isAuthenticated = false;
         }
@@ -116,6 +119,15 @@ const App = {
             case 'edit-member':
                 this.renderEditMember(params[0]);
                 break;
+            case 'duplicates':
+                this.renderDuplicates();
+                break;
+            case 'alert':
+                this.renderAlert(params[0]);
+                break;
+            case 'new-incident':
+                this.renderNewIncident(params[0]);
+                break;
             default:
                 this.renderHome();
         }
@@ -129,6 +141,10 @@ const App = {
                 <h2>TrailCall</h2>
                 <p>Centurion Hiking Club</p>
                 <form id="login-form" class="card">
+                    <div class="form-group">
+                        <label for="username">Username</label>
+                        <input type="text" id="username" placeholder="Username" autocapitalize="none" autocomplete="username">
+                    </div>
                     <div class="form-group">
                         <label for="pin">Enter PIN</label>
                         <input type="password" id="pin" class="pin-input" placeholder="****" maxlength="20" autocomplete="off">
@@ -140,10 +156,12 @@ const App = {
 
         document.getElementById('login-form').addEventListener('submit', async (e) => {
             e.preventDefault();
+            const username = document.getElementById('username').value.trim();
             const pin = document.getElementById('pin').value;
             try {
-                await API.login(pin);
+                const result = await API.login(username, pin);
                 this.isAuthenticated = true;
+                this.user = result.user || null;
 
                 // Cache data for offline use after login
                 if (typeof SyncManager !== 'undefined') {
@@ -154,7 +172,8 @@ const App = {
 
                 window.location.hash = '#home';
             } catch (err) {
-                Toast.show('Invalid PIN', 'error');
+                // Lockouts come back with their own message
+                Toast.show(err.message === 'Unauthorized' ? 'Invalid username or PIN' : err.message, 'error');
             }
         });
     },
@@ -245,7 +264,17 @@ const App = {
         if (!confirm(`Close "${this.currentHike.name}"? This cannot be undone.${warningMsg}`)) return;
 
         try {
-            await API.closeHike(this.currentHike.id);
+            try {
+                await API.closeHike(this.currentHike.id);
+            } catch (err) {
+                // People still out on the trail - make the leader confirm
+                if (err.status !== 409 || !err.data?.headcount) throw err;
+                const outstanding = err.data.headcount.people.filter(p => !p.returned);
+                const names = outstanding.map(p => p.name).join(', ');
+                const reason = prompt(`⚠️ ${err.message}:\n${names}\n\nTo close anyway, give a reason:`);
+                if (!reason || !reason.trim()) return;
+                await API.closeHike(this.currentHike.id, true, reason.trim());
+            }
             Toast.show('Hike closed');
             this.currentHike = null;
             this.renderHome();
@@ -366,6 +395,9 @@ const App = {
                         <h2>${this.currentHike.name}</h2>
                         <p>${this.currentHike.date}</p>
                     </div>
+                    <button class="btn btn-small ${this.scanMode === 'checkout' ? 'btn-danger' : 'btn-secondary'}" onclick="App.toggleScanMode()">
+                        ${this.scanMode === 'checkout' ? 'Scanning: Check-out' : 'Scanning: Check-in'}
+                    </button>
                 </div>
                 ${pendingBanner}
             </div>
@@ -442,6 +474,153 @@ const App = {
         `).join('');
     },
 
+    async toggleScanMode() {
+        this.scanMode = this.scanMode === 'checkout' ? 'checkin' : 'checkout';
+        await Scanner.stop();
+        this.renderScanner();
+    },
+
+    async handleCheckoutScan(code) {
+        const resultDiv = document.getElementById('scan-result');
+
+        if (!navigator.onLine) {
+            resultDiv.innerHTML = `
+                <div class="scan-result error">
+                    <h3>Offline</h3>
+                    <p>Check-out needs a connection</p>
+                </div>
+            `;
+            return;
+        }
+
+        try {
+            const checkin = await API.checkoutMember(this.currentHike.id, code);
+            resultDiv.innerHTML = `
+                <div class="scan-result success">
+                    <h3>${checkin.member_name}</h3>
+                    <p>${checkin.membership_number} - checked out</p>
+                </div>
+            `;
+            this.playBeep();
+        } catch (err) {
+            resultDiv.innerHTML = `
+                <div class="scan-result error">
+                    <h3>Error</h3>
+                    <p>${err.message}</p>
+                </div>
+            `;
+        }
+    },
+
+    async raiseOverdueAlert(hikeId) {
+        const notes = prompt('Raise an overdue alert? Add any details (last known position, who is searching):');
+        if (notes === null) return;
+
+        try {
+            const alert = await API.raiseAlert(hikeId, notes.trim());
+            window.location.hash = `#alert/${alert.id}`;
+        } catch (err) {
+            Toast.show(err.message || 'Failed to raise alert', 'error');
+        }
+    },
+
+    async renderAlert(alertId) {
+        const app = document.getElementById('app');
+        app.innerHTML = '<div class="empty-state">Loading...</div>';
+
+        try {
+            const alert = await API.getAlert(alertId);
+            const sheet = alert.sheet;
+            const time = t => t ? new Date(t).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' }) : '';
+            const people = list => list.length === 0 ? '<p style="color: var(--text-light);">None</p>' : `
+                <ul class="list">
+                    ${list.map(p => `
+                        <li class="list-item">
+                            <div class="list-item-content">
+                                <div class="list-item-title">${p.name}</div>
+                                <div class="list-item-subtitle">
+                                    ${p.is_guest ? 'Guest' : p.membership_number}
+                                    ${p.phone ? ` • <a href="tel:${p.phone}">${p.phone}</a>` : ''}
+                                    • in ${time(p.checked_in_at)}${p.checked_out_at ? ` • out ${time(p.checked_out_at)}` : ''}
+                                </div>
+                            </div>
+                        </li>
+                    `).join('')}
+                </ul>
+            `;
+
+            app.innerHTML = `
+                <div class="card">
+                    <div class="card-header">
+                        <div>
+                            <h2>⚠️ Overdue: ${sheet.hike_name}</h2>
+                            <p>${sheet.hike_date} ${sheet.location ? '• ' + sheet.location : ''}</p>
+                        </div>
+                        <a href="${API.getAlertCSVUrl(alert.id)}" class="download-btn" download>CSV</a>
+                    </div>
+                    <p>Raised by ${alert.raised_by} at ${new Date(alert.raised_at).toLocaleString()}</p>
+                    ${alert.notes ? `<p>${alert.notes}</p>` : ''}
+                    <p><strong>${sheet.outstanding.length} of ${sheet.started}</strong> still out</p>
+                    <button class="btn btn-secondary" onclick="window.print()">Print</button>
+                </div>
+                <div class="card">
+                    <h3>Outstanding</h3>
+                    ${people(sheet.outstanding)}
+                </div>
+                <div class="card">
+                    <h3>Leaders</h3>
+                    ${people(sheet.leaders)}
+                    <h3>Sweepers</h3>
+                    ${people(sheet.sweepers)}
+                </div>
+                <div class="card">
+                    <h3>Resolution</h3>
+                    ${alert.resolved_at ? `
+                        <p>Resolved by ${alert.resolved_by} at ${new Date(alert.resolved_at).toLocaleString()}</p>
+                        <p>${alert.resolution_notes}</p>
+                    ` : `
+                        <div class="form-group">
+                            <textarea id="resolution-notes" rows="3" placeholder="How was this resolved?" style="width: 100%;"></textarea>
+                        </div>
+                        <button class="btn btn-primary btn-block" onclick="App.resolveAlert(${alert.id})">Resolve Alert</button>
+                    `}
+                </div>
+            `;
+        } catch (err) {
+            app.innerHTML = `<div class="empty-state">Could not load alert</div>`;
+        }
+    },
+
+    async resolveAlert(alertId) {
+        const notes = document.getElementById('resolution-notes').value.trim();
+        if (!notes) {
+            Toast.show('Add resolution notes first', 'error');
+            return;
+        }
+
+        try {
+            await API.resolveAlert(alertId, notes);
+            Toast.show('Alert resolved', 'success');
+            this.renderAlert(alertId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to resolve alert', 'error');
+        }
+    },
+
+    async checkoutPerson(checkinId, rsvpId, hikeId) {
+        try {
+            if (checkinId) {
+                await API.checkoutCheckin(checkinId);
+            } else {
+                await API.checkoutRSVP(rsvpId);
+            }
+            Toast.show('Checked out', 'success');
+            this.renderAttendance(hikeId);
+        } catch (err) {
+            Toast.show(err.message || 'Check-out failed', 'error');
+        }
+    },
+
     async handleManualCheckin(rsvp) {
         try {
             if (navigator.onLine) {
@@ -454,7 +633,7 @@ const App = {
 
                 // If it's a member, we can use their membership number for standard offline check-in
                 if (rsvp.membership_number) {
-                    await OfflineStore.addPendingCheckin(this.currentHike.id, rsvp.membership_number);
+                    await OfflineStore.addPendingCheckin(this.currentHike.id, rsvp.membership_number, true);
                 } else {
                     // It's a guest RSVP being checked in offline. 
                     // This is a bit of a special case since bulkCheckin expects a membership number.
@@ -489,6 +668,59 @@ const App = {
         }
     },
 
+    async showHouseholdCheckin(checkin, code) {
+        try {
+            const member = await API.getMember(checkin.member_id);
+            if (!member.household_id) return;
+            const household = await API.getHousehold(member.household_id);
+            const others = household.members.filter(m => m.id !== member.id && m.active);
+            if (others.length === 0) return;
+
+            const resultDiv = document.getElementById('scan-result');
+            if (!resultDiv) return;
+            resultDiv.insertAdjacentHTML('beforeend', `
+                <div id="household-checkin" class="card">
+                    <h3>${household.name}</h3>
+                    <p style="color: var(--text-light); font-size: 0.875rem;">Check in family members on this card</p>
+                    ${others.map(m => `
+                    <label style="display: block; font-weight: normal;">
+                        <input type="checkbox" class="household-member" value="${m.id}" checked> ${m.first_name} ${m.last_name}
+                    </label>
+                    `).join('')}
+                    <button class="btn btn-primary btn-block" id="household-checkin-btn">Check In Selected</button>
+                </div>
+            `);
+
+            document.getElementById('household-checkin-btn').addEventListener('click', async () => {
+                const ids = Array.from(document.querySelectorAll('.household-member:checked'))
+                    .map(el => parseInt(el.value, 10));
+                if (ids.length === 0) {
+                    Toast.show('Select at least one member', 'error');
+                    return;
+                }
+                try {
+                    const results = await API.householdCheckin(this.currentHike.id, code, ids);
+                    document.getElementById('household-checkin').remove();
+                    const names = status => results.filter(r => r.status === status).map(r => r.checkin.member_name);
+                    const created = names('created');
+                    const duplicates = names('duplicate');
+                    if (created.length > 0) {
+                        Toast.show(`Checked in ${created.join(', ')}`, 'success');
+                    }
+                    if (duplicates.length > 0) {
+                        Toast.show(`Already checked in: ${duplicates.join(', ')}`, 'warning');
+                    }
+                    this.loadRecentCheckins();
+                    this.loadRSVPList();
+                } catch (err) {
+                    Toast.show(err.message || 'Check-in failed', 'error');
+                }
+            });
+        } catch (err) {
+            // The cardholder is checked in either way
+        }
+    },
+
     async handleScan(code) {
         const resultDiv = document.getElementById('scan-result');
 
@@ -503,15 +735,21 @@ const App = {
             return;
         }
 
+        if (this.scanMode === 'checkout') {
+            await this.handleCheckoutScan(code);
+            return;
+        }
+
         // If online, use the API
         if (navigator.onLine) {
             try {
                 const checkin = await API.createCheckin(this.currentHike.id, code);
 
                 resultDiv.innerHTML = `
-                    <div class="scan-result success">
+                    <div class="scan-result ${checkin.expired ? 'warning' : 'success'}">
                         <h3>${checkin.member_name}</h3>
                         <p>${checkin.membership_number}</p>
+                        ${checkin.expired ? '<p><strong>Membership expired</strong> - ask them to renew</p>' : ''}
                     </div>
                 `;
 
@@ -527,6 +765,9 @@ const App = {
                 // Update attendee count
                 this.currentHike.attendee_count++;
 
+                // A family card can check in the rest of the household
+                this.showHouseholdCheckin(checkin, code);
+
             } catch (err) {
                 resultDiv.innerHTML = `
                     <div class="scan-result error">
@@ -542,14 +783,15 @@ const App = {
                     throw new Error('Offline store not available');
                 }
 
-                // Look up member from cache
-                const member = await OfflineStore.getCachedMemberByNumber(code);
+                // Look up member from cache. Signed cards are verified by the server when they sync.
+                const number = cardMembershipNumber(code);
+                const member = await OfflineStore.getCachedMemberByNumber(number);
 
                 if (!member) {
                     resultDiv.innerHTML = `
                         <div class="scan-result error">
                             <h3>Member Not Found</h3>
-                            <p>${code} not in cached member list</p>
+                            <p>${number} not in cached member list</p>
                         </div>
                     `;
                     return;
@@ -558,10 +800,12 @@ const App = {
                 // Store pending check-in
                 await OfflineStore.addPendingCheckin(this.currentHike.id, code);
 
+                // The cached status is as of today rather than the hike date, which is close enough offline
                 resultDiv.innerHTML = `
-                    <div class="scan-result success">
+                    <div class="scan-result ${member.membership_expired ? 'warning' : 'success'}">
                         <h3>${member.first_name} ${member.last_name}</h3>
                         <p>${member.membership_number}</p>
+                        ${member.membership_expired ? '<p><strong>Membership expired</strong> - ask them to renew</p>' : ''}
                         <p style="font-size: 0.75rem; color: var(--warning);">Saved offline - will sync later</p>
                     </div>
                 `;
@@ -575,7 +819,7 @@ const App = {
 
                 // Update cached RSVP if applicable
                 const rsvps = await OfflineStore.getCachedRSVPs(this.currentHike.id);
-                const rsvp = rsvps.find(r => r.membership_number === code);
+                const rsvp = rsvps.find(r => r.membership_number === number);
                 if (rsvp) {
                     await OfflineStore.updateCachedRSVPStatus(rsvp.id, true);
                     this.loadRSVPList();
@@ -614,10 +858,11 @@ const App = {
 
                 // Get member names from cache
                 const items = await Promise.all(hikeCheckins.map(async (c) => {
-                    const member = await OfflineStore.getCachedMemberByNumber(c.membershipNumber);
+                    const number = cardMembershipNumber(c.membershipNumber);
+                    const member = await OfflineStore.getCachedMemberByNumber(number);
                     return {
                         name: member ? `${member.first_name} ${member.last_name}` : 'Unknown',
-                        membershipNumber: c.membershipNumber,
+                        membershipNumber: number,
                         timestamp: c.timestamp
                     };
                 }));
@@ -685,6 +930,41 @@ const App = {
                 console.log('Could not load activities');
             }
 
+            let headcount = null;
+            try {
+                headcount = await API.getHeadcount(hikeId);
+            } catch (e) {
+                console.log('Could not load headcount');
+            }
+            const stillOut = headcount ? headcount.people.filter(p => !p.returned) : [];
+
+            let incidents = [];
+            try {
+                incidents = await API.getIncidents(hikeId) || [];
+            } catch (e) {
+                console.log('Could not load incidents');
+            }
+
+            let leaders = [];
+            try {
+                leaders = await API.getHikeLeaders(hikeId) || [];
+            } catch (e) {
+                console.log('Could not load leaders');
+            }
+            // The roster is for admins and the hike's leaders, while the hike
+            // is open or still has an unresolved alert
+            let openAlerts = [];
+            if (hike.status === 'closed') {
+                try {
+                    openAlerts = await API.getHikeAlerts(hikeId, 'open') || [];
+                } catch (e) {
+                    console.log('Could not load alerts');
+                }
+            }
+            const canViewRoster = (this.user?.role === 'admin' ||
+                (this.user?.role === 'leader' && leaders.some(l => l.id === this.user.id))) &&
+                (hike.status !== 'closed' || openAlerts.length > 0);
+
             const checkedInIds = new Set(attendees.map(a => a.id));
             const rsvpNotCheckedIn = rsvps.filter(r => !r.checked_in);
             const checkedInGuests = rsvps.filter(r => r.checked_in && !r.member_id);
@@ -695,10 +975,21 @@ const App = {
                         <div>
                             <h2>${hike.name}</h2>
                             <p>${hike.date} ${hike.location ? '• ' + hike.location : ''}</p>
+                            <p>Leaders: ${leaders.length ? leaders.map(l => l.name).join(', ') : 'none assigned'}
+                                ${this.user?.role === 'admin' ? `<button class="btn btn-small btn-secondary" onclick="App.editHikeLeaders(${hikeId}, '${leaders.map(l => l.username).join(', ')}')">Change</button>` : ''}
+                            </p>
+                        </div>
+                        <div style="display: flex; gap: 8px; align-items: center;">
+                            ${hike.status === 'closed' && this.user?.role === 'admin' ? `
+                            <button class="btn btn-small btn-secondary" onclick="App.reopenHike(${hikeId})">Reopen</button>
+                            ` : ''}
+                            ${canViewRoster ? `
+                            <a href="${API.getEmergencyRosterUrl(hikeId)}" class="download-btn" target="_blank" title="Emergency contacts and medical notes">Roster</a>
+                            ` : ''}
+                            <a href="${API.getHikeCSVUrl(hikeId)}" class="download-btn" download>
+                                CSV
+                            </a>
                         </div>
-                        <a href="${API.getHikeCSVUrl(hikeId)}" class="download-btn" download>
-                            CSV
-                        </a>
                     </div>
                     <div class="stats-row">
                         <div class="stat-card">
@@ -716,6 +1007,37 @@ const App = {
                     </div>
                 </div>
 
+                ${headcount && headcount.started > 0 ? `
+                <div class="card">
+                    <div class="card-header">
+                        <h3>Headcount</h3>
+                        <span>${headcount.returned} / ${headcount.started} back</span>
+                    </div>
+                    ${stillOut.length > 0 ? `
+                    <button class="btn btn-danger btn-block" style="margin-bottom: 12px;" onclick="App.raiseOverdueAlert(${hikeId})">
+                        Raise Overdue Alert
+                    </button>
+                    ` : ''}
+                    ${stillOut.length === 0 ? '<p style="color: var(--text-light); font-size: 0.875rem;">Everyone is accounted for</p>' : `
+                    <ul class="list">
+                        ${stillOut.map(p => `
+                            <li class="list-item">
+                                <div class="list-item-content">
+                                    <div class="list-item-title">${p.name}</div>
+                                    <div class="list-item-subtitle">${p.membership_number || 'Guest'}</div>
+                                </div>
+                                ${hike.status !== 'closed' ? `
+                                <button class="btn btn-small btn-success" onclick="App.checkoutPerson(${p.checkin_id || 'null'}, ${p.rsvp_id || 'null'}, ${hikeId})">
+                                    Back
+                                </button>
+                                ` : ''}
+                            </li>
+                        `).join('')}
+                    </ul>
+                    `}
+                </div>
+                ` : ''}
+
                 <div class="card">
                     <div class="card-header">
                         <h3>Activities</h3>
@@ -746,6 +1068,29 @@ const App = {
                     `}
                 </div>
 
+                <div class="card">
+                    <div class="card-header">
+                        <h3>Incidents</h3>
+                        <button class="btn btn-small btn-primary" onclick="window.location.hash='#new-incident/${hikeId}'">
+                            + Log
+                        </button>
+                    </div>
+                    ${incidents.length === 0 ? '<p style="color: var(--text-light); font-size: 0.875rem;">No incidents logged</p>' : `
+                    <ul class="list">
+                        ${incidents.map(i => `
+                            <li class="list-item">
+                                <div class="list-item-content">
+                                    <div class="list-item-title">${i.severity.replace('_', ' ')}: ${i.description}</div>
+                                    <div class="list-item-subtitle">
+                                        ${i.people.length > 0 ? i.people.map(p => p.name).join(', ') + ' • ' : ''}reported by ${i.reported_by || 'unknown'}
+                                    </div>
+                                </div>
+                            </li>
+                        `).join('')}
+                    </ul>
+                    `}
+                </div>
+
                 <div class="card">
                     <div class="card-header">
                         <h3>RSVP Link</h3>
@@ -804,6 +1149,7 @@ const App = {
                                             ${m.first_name} ${m.last_name}
                                             ${checkin && checkin.is_leader ? '<span class="badge badge-accent">Leader</span>' : ''}
                                             ${checkin && checkin.is_sweeper ? '<span class="badge badge-secondary">Sweeper</span>' : ''}
+                                            ${checkin && checkin.expired ? '<span class="badge badge-warning">Lapsed</span>' : ''}
                                         </div>
                                         <div class="list-item-subtitle">${m.membership_number}</div>
                                     </div>
@@ -1053,6 +1399,25 @@ const App = {
         }
     },
 
+    importSummary(preview) {
+        const lines = [
+            `Create ${preview.created}, update ${preview.updated}, deactivate ${preview.deactivated}, ` +
+            `unchanged ${preview.unchanged}, skipped ${preview.skipped}.`,
+        ];
+        const changed = preview.rows.filter(row => ['update', 'deactivate'].includes(row.action));
+        changed.slice(0, 10).forEach(row => {
+            const changes = Object.entries(row.changes || {})
+                .map(([col, c]) => `${col}: "${c.from}" → "${c.to}"`)
+                .join(', ');
+            lines.push(`${row.membership_number} ${row.name} (${row.action}) ${changes}`);
+        });
+        if (changed.length > 10) {
+            lines.push(`...and ${changed.length - 10} more`);
+        }
+        lines.push('', 'Apply these changes?');
+        return lines.join('\n');
+    },
+
     async renderMembers() {
         const app = document.getElementById('app');
         app.innerHTML = '<div class="empty-state">Loading...</div>';
@@ -1064,11 +1429,21 @@ const App = {
                 <div class="toolbar">
                     <input type="search" id="member-search" placeholder="Search members...">
                     <div class="toolbar-buttons">
+                        ${this.user?.role === 'admin' || this.user?.role === 'leader' ? `<a href="${API.getMemberCardsPDFUrl()}" class="btn btn-secondary btn-small" target="_blank">Print Cards</a>` : ''}
                         <button class="btn btn-secondary btn-small" onclick="document.getElementById('csv-import').click()">Import CSV</button>
+                        ${this.user?.role === 'admin' ? `<button class="btn btn-secondary btn-small" onclick="window.location.hash='#duplicates'">Duplicates</button>` : ''}
                         <button class="btn btn-primary btn-small" onclick="window.location.hash='#new-member'">+ Add</button>
                     </div>
                     <input type="file" id="csv-import" accept=".csv" style="display:none">
                 </div>
+                <div class="toolbar">
+                    <label for="import-mode">Import mode</label>
+                    <select id="import-mode">
+                        <option value="insert">Add new members only</option>
+                        <option value="upsert">Add and update</option>
+                        <option value="sync">Add, update and deactivate missing</option>
+                    </select>
+                </div>
                 <div class="card">
                     <ul class="list" id="members-list">
                         ${this.renderMembersList(this.members)}
@@ -1080,10 +1455,17 @@ const App = {
                 const file = e.target.files[0];
                 if (!file) return;
 
+                const mode = document.getElementById('import-mode').value;
                 try {
-                    const result = await API.importMembersCSV(file);
-                    Toast.show(`Imported ${result.imported}, skipped ${result.skipped} duplicates`, 'success');
-                    this.renderMembers(); // Refresh list
+                    // Preview first, then apply only once the user has seen the changes
+                    const preview = await API.importMembersCSV(file, mode, true);
+                    if (preview.errors > 0) {
+                        Toast.show(`Import has ${preview.errors} error(s), nothing was saved: ${preview.error_messages.slice(0, 3).join('; ')}`, 'error');
+                    } else if (confirm(this.importSummary(preview))) {
+                        const result = await API.importMembersCSV(file, mode, false);
+                        Toast.show(`Created ${result.created}, updated ${result.updated}, deactivated ${result.deactivated}, skipped ${result.skipped}`, 'success');
+                        this.renderMembers(); // Refresh list
+                    }
                 } catch (err) {
                     Toast.show('Import failed: ' + err.message, 'error');
                 }
@@ -1104,6 +1486,62 @@ const App = {
         }
     },
 
+    async renderDuplicates() {
+        const app = document.getElementById('app');
+        app.innerHTML = '<div class="empty-state">Loading...</div>';
+
+        try {
+            const candidates = await API.getDuplicates();
+            const describe = m => `
+                <div class="list-item-title">${m.first_name} ${m.last_name}</div>
+                <div class="list-item-subtitle">${m.membership_number}${m.email ? ' • ' + m.email : ''}${m.phone ? ' • ' + m.phone : ''}</div>
+            `;
+
+            app.innerHTML = `
+                <div class="card">
+                    <div class="card-header">
+                        <h2>Possible Duplicates</h2>
+                        <a href="${API.getDuplicatesCSVUrl()}" class="download-btn" download>Download CSV</a>
+                    </div>
+                    <p style="color: var(--text-light); font-size: 0.875rem;">
+                        Members with similar names or the same email or phone. Merging keeps one record,
+                        moves the other's check-ins, RSVPs and activities to it, and deactivates the other.
+                    </p>
+                </div>
+                ${candidates.length === 0 ? '<div class="card"><div class="empty-state">No possible duplicates found</div></div>' : candidates.map(c => `
+                    <div class="card">
+                        <p style="font-size: 0.875rem;">Matched on ${c.reasons.join(', ')}${c.reasons.includes('name') ? ` (${c.name_score}%)` : ''}</p>
+                        <ul class="list">
+                            <li class="list-item">
+                                <div class="list-item-content">${describe(c.member)}</div>
+                                <button class="btn btn-small btn-secondary" onclick="App.mergeMembers(${c.member.id}, ${c.match.id})">Keep</button>
+                            </li>
+                            <li class="list-item">
+                                <div class="list-item-content">${describe(c.match)}</div>
+                                <button class="btn btn-small btn-secondary" onclick="App.mergeMembers(${c.match.id}, ${c.member.id})">Keep</button>
+                            </li>
+                        </ul>
+                    </div>
+                `).join('')}
+            `;
+        } catch (err) {
+            app.innerHTML = `<div class="card"><div class="empty-state">Failed to load duplicates</div></div>`;
+        }
+    },
+
+    async mergeMembers(survivorId, duplicateId) {
+        if (!confirm('Merge these members? The other record will be deactivated and its card will stop working.')) return;
+
+        try {
+            const result = await API.mergeMembers(survivorId, duplicateId);
+            const moved = result.checkins_moved + result.checkins_merged;
+            Toast.show(`Merged into ${result.survivor.first_name} ${result.survivor.last_name} (${moved} check-ins)`, 'success');
+            this.renderDuplicates();
+        } catch (err) {
+            Toast.show(err.message || 'Failed to merge members', 'error');
+        }
+    },
+
     renderMembersList(members) {
         if (members.length === 0) {
             return '<li class="empty-state">No members found</li>';
@@ -1123,22 +1561,80 @@ const App = {
         app.innerHTML = '<div class="empty-state">Loading...</div>';
 
         try {
-            const member = await API.getMember(memberId);
+            const [member, periods, fields] = await Promise.all([
+                API.getMember(memberId), API.getMemberships(memberId), API.getCustomFields(),
+            ]);
+            const household = member.household_id ? await API.getHousehold(member.household_id) : null;
+            const canEdit = this.user?.role === 'admin' || this.user?.role === 'leader';
 
             app.innerHTML = `
                 <div class="card">
                     <h2>${member.first_name} ${member.last_name}</h2>
                     <p>${member.membership_number}</p>
+                    ${canEdit ? `
                     <div class="qr-display">
-                        <img src="${API.getMemberQRUrl(memberId)}" alt="QR Code">
+                        <img src="${API.getMemberQRUrl(memberId, member.card_version)}" alt="QR Code">
                         <p>Scan this code for check-in</p>
+                        <a href="${API.getMemberCardsPDFUrl([memberId])}" class="download-btn" target="_blank">Print Card</a>
+                        <a href="${API.getMemberCodeUrl(memberId, { output: 'svg', v: member.card_version })}" class="download-btn" download>QR (SVG)</a>
+                        <a href="${API.getMemberCodeUrl(memberId, { format: 'code128', output: 'svg', v: member.card_version })}" class="download-btn" download>Barcode (SVG)</a>
+                        <a href="${API.getAppleWalletUrl(memberId)}" class="download-btn">Apple Wallet</a>
+                        <a href="#" class="download-btn" onclick="App.openGoogleWallet(${memberId}); return false;">Google Wallet</a>
                     </div>
+                    ` : ''}
                 </div>
                 <div class="card">
                     <h3>Details</h3>
                     <p><strong>Email:</strong> ${member.email || 'Not set'}</p>
                     <p><strong>Phone:</strong> ${member.phone || 'Not set'}</p>
                     <p><strong>Status:</strong> ${member.active ? 'Active' : 'Inactive'}</p>
+                    <p><strong>Emergency details:</strong> ${member.has_emergency_info ? 'On file' : 'Not set'}</p>
+                    ${fields.map(f => `
+                        <p><strong>${f.label}:</strong> ${this.formatCustomField(f, (member.custom_fields || {})[f.key])}</p>
+                    `).join('')}
+                </div>
+                <div class="card">
+                    <h3>Membership ${member.membership_expired ? '<span class="badge badge-warning">Expired</span>' : ''}</h3>
+                    ${periods.length === 0 ? '<p style="color: var(--text-light); font-size: 0.875rem;">No membership periods recorded</p>' : `
+                        <ul class="list">
+                            ${periods.map(p => `
+                                <li class="list-item">
+                                    <div class="list-item-content">
+                                        <div class="list-item-title">${p.type.charAt(0).toUpperCase() + p.type.slice(1)}</div>
+                                        <div class="list-item-subtitle">${p.start_date} to ${p.end_date || 'no end date'}</div>
+                                    </div>
+                                    ${canEdit ? `<button class="btn btn-small btn-outline" onclick="App.deleteMembershipPeriod(${memberId}, ${p.id})">Remove</button>` : ''}
+                                </li>
+                            `).join('')}
+                        </ul>
+                    `}
+                    ${canEdit ? `
+                        <button class="btn btn-secondary btn-block" onclick="App.renewMembership(${memberId})">
+                            Renew Membership
+                        </button>
+                    ` : ''}
+                </div>
+                <div class="card">
+                    <h3>Household${household ? ': ' + household.name : ''}</h3>
+                    ${!household ? '<p style="color: var(--text-light); font-size: 0.875rem;">Not part of a household</p>' : `
+                        <ul class="list">
+                            ${household.members.map(m => `
+                                <li class="list-item" onclick="window.location.hash='#member/${m.id}'">
+                                    <div class="list-item-content">
+                                        <div class="list-item-title">${m.first_name} ${m.last_name}</div>
+                                        <div class="list-item-subtitle">${m.membership_number}${m.id === household.primary_member_id ? ' • Primary contact' : ''}</div>
+                                    </div>
+                                </li>
+                            `).join('')}
+                        </ul>
+                    `}
+                    ${canEdit ? (household ? `
+                        <button class="btn btn-secondary btn-block" onclick="App.addHouseholdMember(${memberId}, ${household.id})">Add Family Member</button>
+                        ${household.primary_member_id !== member.id ? `<button class="btn btn-secondary btn-block" onclick="App.setHouseholdPrimary(${memberId}, ${household.id})">Make Primary Contact</button>` : ''}
+                        <button class="btn btn-outline btn-block" onclick="App.leaveHousehold(${memberId}, ${household.id})">Remove from Household</button>
+                    ` : `
+                        <button class="btn btn-secondary btn-block" onclick="App.createHousehold(${memberId})">Create Household</button>
+                    `) : ''}
                 </div>
                 <div class="stats-row">
                     <button class="btn btn-secondary btn-block" onclick="window.location.hash='#member-history/${memberId}'">
@@ -1202,8 +1698,9 @@ const App = {
         }
     },
 
-    renderNewMember() {
+    async renderNewMember() {
         const app = document.getElementById('app');
+        const fields = await API.getCustomFields().catch(() => []);
         app.innerHTML = `
             <div class="card">
                 <h2>Add New Member</h2>
@@ -1228,6 +1725,7 @@ const App = {
                         <label for="phone">Phone</label>
                         <input type="tel" id="phone">
                     </div>
+                    ${this.customFieldInputs(fields, {})}
                     <button type="submit" class="btn btn-primary btn-block">Add Member</button>
                 </form>
             </div>
@@ -1242,6 +1740,7 @@ const App = {
                     last_name: document.getElementById('last-name').value,
                     email: document.getElementById('email').value,
                     phone: document.getElementById('phone').value,
+                    custom_fields: this.readCustomFields(fields),
                 });
                 Toast.show('Member added!', 'success');
                 window.location.hash = `#member/${member.id}`;
@@ -1256,7 +1755,7 @@ const App = {
         app.innerHTML = '<div class="empty-state">Loading...</div>';
 
         try {
-            const member = await API.getMember(memberId);
+            const [member, fields] = await Promise.all([API.getMember(memberId), API.getCustomFields()]);
 
             app.innerHTML = `
                 <div class="card">
@@ -1282,9 +1781,50 @@ const App = {
                             <label for="phone">Phone</label>
                             <input type="tel" id="phone" value="${member.phone || ''}">
                         </div>
+                        ${this.customFieldInputs(fields, member.custom_fields || {})}
                         <button type="submit" class="btn btn-primary btn-block">Save Changes</button>
                     </form>
                 </div>
+                <div class="card">
+                    <h3>Emergency Details</h3>
+                    <p style="color: var(--text-light); font-size: 0.875rem;">
+                        ${member.has_emergency_info ? 'Details are on file.' : 'No details on file.'}
+                        Saved details are only shown on a hike's emergency roster, to admins and the hike's assigned leaders. Saving replaces them; save empty to remove.
+                    </p>
+                    <form id="emergency-form">
+                        <div class="form-group">
+                            <label for="emergency-name">Next of Kin Name</label>
+                            <input type="text" id="emergency-name">
+                        </div>
+                        <div class="form-group">
+                            <label for="emergency-phone">Next of Kin Phone</label>
+                            <input type="tel" id="emergency-phone">
+                        </div>
+                        <div class="form-group">
+                            <label for="medical-notes">Medical Notes</label>
+                            <textarea id="medical-notes" rows="3"></textarea>
+                        </div>
+                        <button type="submit" class="btn btn-secondary btn-block">Save Emergency Details</button>
+                    </form>
+                </div>
+                <div class="card">
+                    <h3>Membership Card</h3>
+                    <p style="color: var(--text-light); font-size: 0.875rem;">
+                        If a card is lost, revoke it so it can no longer be used to check in, then print a new one.
+                    </p>
+                    <button class="btn btn-secondary btn-block" onclick="App.revokeCard(${memberId})">
+                        Revoke Card
+                    </button>
+                    <p style="color: var(--text-light); font-size: 0.875rem;">
+                        Send the member a link to add their card to Apple or Google Wallet. It works for 30 days.
+                    </p>
+                    <button class="btn btn-secondary btn-block" onclick="App.copyWalletLink(${memberId})">
+                        Copy Wallet Link
+                    </button>
+                    <button class="btn btn-secondary btn-block" onclick="App.deleteWalletLinks(${memberId})">
+                        Cancel Wallet Links
+                    </button>
+                </div>
                 <div class="card">
                     <button class="btn btn-danger btn-block" onclick="App.deleteMember(${memberId})">
                         Deactivate Member
@@ -1301,6 +1841,7 @@ const App = {
                         last_name: document.getElementById('last-name').value,
                         email: document.getElementById('email').value,
                         phone: document.getElementById('phone').value,
+                        custom_fields: this.readCustomFields(fields),
                     });
                     Toast.show('Member updated!', 'success');
                     window.location.hash = `#member/${memberId}`;
@@ -1308,11 +1849,209 @@ const App = {
                     Toast.show(err.message || 'Failed to update member', 'error');
                 }
             });
+
+            document.getElementById('emergency-form').addEventListener('submit', async (e) => {
+                e.preventDefault();
+                try {
+                    await API.setEmergencyInfo(memberId, {
+                        emergency_contact_name: document.getElementById('emergency-name').value,
+                        emergency_contact_phone: document.getElementById('emergency-phone').value,
+                        medical_notes: document.getElementById('medical-notes').value,
+                    });
+                    Toast.show('Emergency details saved', 'success');
+                    window.location.hash = `#member/${memberId}`;
+                } catch (err) {
+                    Toast.show(err.message || 'Failed to save emergency details', 'error');
+                }
+            });
         } catch (err) {
             app.innerHTML = `<div class="card"><div class="empty-state">Failed to load member</div></div>`;
         }
     },
 
+    // Form inputs for the club's custom fields, filled from values by key
+    customFieldInputs(fields, values) {
+        return fields.map(f => {
+            const id = `custom-${f.key}`;
+            const value = values[f.key] || '';
+            const label = `<label for="${id}">${f.label}${f.required ? ' *' : ''}</label>`;
+            let input;
+            switch (f.type) {
+                case 'select':
+                    input = `<select id="${id}" ${f.required ? 'required' : ''}>
+                        <option value=""></option>
+                        ${f.options.map(o => `<option value="${o}" ${o === value ? 'selected' : ''}>${o}</option>`).join('')}
+                    </select>`;
+                    break;
+                case 'boolean':
+                    input = `<select id="${id}" ${f.required ? 'required' : ''}>
+                        <option value=""></option>
+                        <option value="true" ${value === 'true' ? 'selected' : ''}>Yes</option>
+                        <option value="false" ${value === 'false' ? 'selected' : ''}>No</option>
+                    </select>`;
+                    break;
+                default: {
+                    const type = { number: 'number', date: 'date' }[f.type] || 'text';
+                    input = `<input type="${type}" id="${id}" value="${value}" ${type === 'number' ? 'step="any"' : ''} ${f.required ? 'required' : ''}>`;
+                }
+            }
+            return `<div class="form-group">${label}${input}</div>`;
+        }).join('');
+    },
+
+    readCustomFields(fields) {
+        const values = {};
+        fields.forEach(f => {
+            values[f.key] = document.getElementById(`custom-${f.key}`).value;
+        });
+        return values;
+    },
+
+    formatCustomField(field, value) {
+        if (!value) return 'Not set';
+        if (field.type === 'boolean') return value === 'true' ? 'Yes' : 'No';
+        return value;
+    },
+
+    async renewMembership(memberId) {
+        try {
+            const period = await API.renewMembership(memberId);
+            Toast.show(`Renewed until ${period.end_date || 'further notice'}`, 'success');
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to renew membership', 'error');
+        }
+    },
+
+    async deleteMembershipPeriod(memberId, periodId) {
+        if (!confirm('Remove this membership period?')) return;
+
+        try {
+            await API.deleteMembershipPeriod(memberId, periodId);
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to remove membership period', 'error');
+        }
+    },
+
+    async createHousehold(memberId) {
+        try {
+            const member = await API.getMember(memberId);
+            const name = prompt('Household name:', `${member.last_name} family`);
+            if (!name) return;
+            await API.createHousehold({ name, primary_member_id: memberId, member_ids: [memberId] });
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to create household', 'error');
+        }
+    },
+
+    // updateHousehold replaces the whole household, so changes start from its current state
+    async saveHousehold(householdId, change) {
+        const household = await API.getHousehold(householdId);
+        const data = {
+            name: household.name,
+            primary_member_id: household.primary_member_id ?? null,
+            member_ids: household.members.map(m => m.id),
+        };
+        change(data);
+        return API.updateHousehold(householdId, data);
+    },
+
+    async addHouseholdMember(memberId, householdId) {
+        const number = prompt('Membership number of the family member to add:');
+        if (!number) return;
+
+        try {
+            const members = await API.getMembers(false);
+            const other = members.find(m => m.membership_number.toLowerCase() === number.trim().toLowerCase());
+            if (!other) {
+                Toast.show(`No member with number ${number}`, 'error');
+                return;
+            }
+            if (other.household_id && other.household_id !== householdId &&
+                !confirm(`${other.first_name} ${other.last_name} is in ${other.household_name}. Move them to this household?`)) {
+                return;
+            }
+            await this.saveHousehold(householdId, data => {
+                if (!data.member_ids.includes(other.id)) data.member_ids.push(other.id);
+            });
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to add family member', 'error');
+        }
+    },
+
+    async setHouseholdPrimary(memberId, householdId) {
+        try {
+            await this.saveHousehold(householdId, data => { data.primary_member_id = memberId; });
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to update household', 'error');
+        }
+    },
+
+    async leaveHousehold(memberId, householdId) {
+        if (!confirm('Remove this member from the household?')) return;
+
+        try {
+            const household = await API.getHousehold(householdId);
+            if (household.members.length <= 1) {
+                await API.deleteHousehold(householdId);
+            } else {
+                await this.saveHousehold(householdId, data => {
+                    data.member_ids = data.member_ids.filter(id => id !== memberId);
+                    if (data.primary_member_id === memberId) data.primary_member_id = null;
+                });
+            }
+            this.renderMemberDetail(memberId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to update household', 'error');
+        }
+    },
+
+    async revokeCard(memberId) {
+        if (!confirm('Revoke this member\'s card? Cards printed so far will stop working and a new card must be printed.')) return;
+
+        try {
+            await API.revokeCard(memberId);
+            Toast.show('Card revoked. Print a new card.', 'success');
+            window.location.hash = `#member/${memberId}`;
+        } catch (err) {
+            Toast.show(err.message || 'Failed to revoke card', 'error');
+        }
+    },
+
+    async openGoogleWallet(memberId) {
+        try {
+            const pass = await API.getGoogleWalletPass(memberId);
+            window.open(pass.save_url, '_blank');
+        } catch (err) {
+            Toast.show(err.message || 'Google Wallet is not available', 'error');
+        }
+    },
+
+    async copyWalletLink(memberId) {
+        try {
+            const link = await API.createWalletLink(memberId);
+            await navigator.clipboard.writeText(window.location.origin + link.url);
+            Toast.show('Wallet link copied!', 'success');
+        } catch (err) {
+            Toast.show(err.message || 'Could not create wallet link', 'error');
+        }
+    },
+
+    async deleteWalletLinks(memberId) {
+        if (!confirm('Cancel every wallet link sent to this member? Passes already added to a wallet are not affected.')) return;
+
+        try {
+            await API.deleteWalletLinks(memberId);
+            Toast.show('Wallet links cancelled', 'success');
+        } catch (err) {
+            Toast.show(err.message || 'Could not cancel wallet links', 'error');
+        }
+    },
+
     async deleteMember(memberId) {
         if (!confirm('Deactivate this member? They will no longer appear in the active members list.')) return;
 
@@ -1334,6 +2073,32 @@ const App = {
         });
     },
 
+    async editHikeLeaders(hikeId, current) {
+        const input = prompt('Usernames of this hike\'s leaders, separated by commas:', current);
+        if (input === null) return;
+
+        try {
+            await API.setHikeLeaders(hikeId, input.split(',').map(u => u.trim()).filter(Boolean));
+            Toast.show('Leaders updated', 'success');
+            this.renderAttendance(hikeId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to update leaders', 'error');
+        }
+    },
+
+    async reopenHike(hikeId) {
+        const reason = prompt('Why is this hike being reopened?');
+        if (!reason || !reason.trim()) return;
+
+        try {
+            await API.reopenHike(hikeId, reason.trim());
+            Toast.show('Hike reopened', 'success');
+            this.renderAttendance(hikeId);
+        } catch (err) {
+            Toast.show(err.message || 'Failed to reopen hike', 'error');
+        }
+    },
+
     async toggleRSVP(hikeId, currentlyOpen) {
         try {
             if (currentlyOpen) {
@@ -1391,6 +2156,11 @@ const App = {
                     <input type="search" id="hike-search" placeholder="Search hikes...">
                     <a href="${API.getFullAttendanceCSVUrl(currentYear)}" class="download-btn" download title="Full attendance for ${currentYear}">Attendance</a>
                     <a href="${API.getAllHikesCSVUrl()}" class="download-btn" download title="Hike summary">Hikes</a>
+                    <a href="${API.getIncidentsCSVUrl(currentYear)}" class="download-btn" download title="Incidents for ${currentYear}">Incidents</a>
+                    <a href="${API.getLapsedCSVUrl(currentYear)}" class="download-btn" download title="Hiked while lapsed in ${currentYear}">Lapsed</a>
+                    <a href="${API.getHouseholdsCSVUrl(currentYear)}" class="download-btn" download title="Attendance by household for ${currentYear}">Households</a>
+                    <a href="${API.getMembersExportUrl('csv')}" class="download-btn" download title="All members, in the import format">Members</a>
+                    <a href="${API.getMembersExportUrl('vcf', { status: 'active' })}" class="download-btn" download title="Active members as contacts">Contacts</a>
                 </div>
                 <div class="card">
                     <ul class="list" id="hikes-list">
@@ -1433,11 +2203,97 @@ const App = {
         }
     },
 
+    async renderNewIncident(hikeId) {
+        const app = document.getElementById('app');
+        app.innerHTML = '<div class="empty-state">Loading...</div>';
+
+        // Offline there is no check-in list, so people are typed in by membership number
+        let checkins = null;
+        try {
+            checkins = await API.getHikeCheckins(hikeId) || [];
+        } catch (e) {
+            console.log('Could not load check-ins');
+        }
+
+        app.innerHTML = `
+            <div class="card">
+                <h2>Log Incident</h2>
+                <form id="incident-form">
+                    <div class="form-group">
+                        <label for="incident-severity">Severity *</label>
+                        <select id="incident-severity" required>
+                            <option value="near_miss">Near miss</option>
+                            <option value="minor">Minor</option>
+                            <option value="moderate">Moderate</option>
+                            <option value="serious">Serious</option>
+                        </select>
+                    </div>
+                    <div class="form-group">
+                        <label for="incident-description">What happened? *</label>
+                        <textarea id="incident-description" rows="3" required></textarea>
+                    </div>
+                    <div class="form-group">
+                        <label for="incident-actions">Actions Taken</label>
+                        <textarea id="incident-actions" rows="3"></textarea>
+                    </div>
+                    <div class="form-group">
+                        <label>People Involved</label>
+                        ${checkins === null ? `
+                        <input type="text" id="incident-numbers" placeholder="Membership numbers, comma separated">
+                        ` : checkins.length === 0 ? '<p style="color: var(--text-light); font-size: 0.875rem;">Nobody is checked in</p>' : checkins.map(c => `
+                        <label style="display: block; font-weight: normal;">
+                            <input type="checkbox" class="incident-person" value="${c.membership_number}"> ${c.member_name}
+                        </label>
+                        `).join('')}
+                    </div>
+                    <button type="submit" class="btn btn-primary btn-block">Save Incident</button>
+                </form>
+            </div>
+        `;
+
+        document.getElementById('incident-form').addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const numbersInput = document.getElementById('incident-numbers');
+            const membershipNumbers = numbersInput
+                ? numbersInput.value.split(',').map(n => n.trim()).filter(n => n)
+                : [...document.querySelectorAll('.incident-person:checked')].map(el => el.value);
+            const incident = {
+                severity: document.getElementById('incident-severity').value,
+                description: document.getElementById('incident-description').value,
+                actions_taken: document.getElementById('incident-actions').value,
+                occurred_at: new Date().toISOString(),
+                membership_numbers: membershipNumbers,
+            };
+
+            try {
+                await API.createIncident(hikeId, incident);
+                Toast.show('Incident logged', 'success');
+            } catch (err) {
+                // fetch throws a TypeError when the network is down, so queue it
+                if (!(err instanceof TypeError) || typeof OfflineStore === 'undefined') {
+                    Toast.show(err.message || 'Failed to log incident', 'error');
+                    return;
+                }
+                await OfflineStore.addPendingIncident(parseInt(hikeId), incident);
+                Toast.show('Saved offline - incident will sync when back online', 'warning');
+            }
+            window.location.hash = `#hike/${hikeId}`;
+        });
+    },
+
     async renderHikeDetail(hikeId) {
         await this.renderAttendance(hikeId);
     },
 };
 
+// cardMembershipNumber pulls the membership number out of a signed card
+// payload (TC1:<number>:<version>:<signature>); plain codes are returned as-is
+function cardMembershipNumber(code) {
+    if (!code.startsWith('TC1:')) return code;
+    const parts = code.slice(4).split(':');
+    return parts.slice(0, -2).join(':');
+}
+
 // Toast notifications
 const Toast = {
     show(message, type = '') {
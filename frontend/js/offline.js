@@ -1,8 +1,15 @@
-// Offline support with IndexedDB for pending check-ins
+// Offline support with IndexedDB for pending check-ins and incidents
+
+function generateClientId() {
+    if (crypto.randomUUID) {
+        return crypto.randomUUID();
+    }
+    return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+}
 
 const OfflineStore = {
     dbName: 'trailcall',
-    dbVersion: 2,
+    dbVersion: 3,
     db: null,
 
     async init() {
@@ -24,6 +31,11 @@ const OfflineStore = {
                     store.createIndex('hikeId', 'hikeId', { unique: false });
                 }
 
+                // Store for incidents logged while offline
+                if (!db.objectStoreNames.contains('pendingIncidents')) {
+                    db.createObjectStore('pendingIncidents', { keyPath: 'id', autoIncrement: true });
+                }
+
                 // Store for cached members (for offline display)
                 if (!db.objectStoreNames.contains('members')) {
                     db.createObjectStore('members', { keyPath: 'id' });
@@ -44,13 +56,16 @@ const OfflineStore = {
     },
 
     // Pending check-ins
-    async addPendingCheckin(hikeId, membershipNumber) {
+    // manual is set when a leader picked the member rather than scanning a card
+    async addPendingCheckin(hikeId, membershipNumber, manual = false) {
         const tx = this.db.transaction('pendingCheckins', 'readwrite');
         const store = tx.objectStore('pendingCheckins');
 
         await store.add({
             hikeId,
             membershipNumber,
+            manual,
+            clientId: generateClientId(),
             timestamp: new Date().toISOString(),
         });
 
@@ -87,7 +102,50 @@ const OfflineStore = {
 
     async getPendingCount() {
         const pending = await this.getPendingCheckins();
-        return pending.length;
+        const incidents = await this.getPendingIncidents();
+        return pending.length + incidents.length;
+    },
+
+    // Pending incidents
+    async addPendingIncident(hikeId, incident) {
+        const tx = this.db.transaction('pendingIncidents', 'readwrite');
+        const store = tx.objectStore('pendingIncidents');
+
+        await store.add({
+            ...incident,
+            hike_id: hikeId,
+            client_id: generateClientId(),
+        });
+
+        return new Promise((resolve, reject) => {
+            tx.oncomplete = resolve;
+            tx.onerror = () => reject(tx.error);
+        });
+    },
+
+    async getPendingIncidents() {
+        const tx = this.db.transaction('pendingIncidents', 'readonly');
+        const store = tx.objectStore('pendingIncidents');
+        const request = store.getAll();
+
+        return new Promise((resolve, reject) => {
+            request.onsuccess = () => resolve(request.result);
+            request.onerror = () => reject(request.error);
+        });
+    },
+
+    async clearPendingIncidents(ids) {
+        const tx = this.db.transaction('pendingIncidents', 'readwrite');
+        const store = tx.objectStore('pendingIncidents');
+
+        for (const id of ids) {
+            store.delete(id);
+        }
+
+        return new Promise((resolve, reject) => {
+            tx.oncomplete = resolve;
+            tx.onerror = () => reject(tx.error);
+        });
     },
 
     // Member cache
@@ -225,6 +283,16 @@ const OfflineStore = {
 
 // Sync manager
 const SyncManager = {
+    // Stable identifier for this device, sent with synced check-ins
+    deviceId() {
+        let id = localStorage.getItem('trailcall_device_id');
+        if (!id) {
+            id = generateClientId();
+            localStorage.setItem('trailcall_device_id', id);
+        }
+        return id;
+    },
+
     async sync() {
         if (!navigator.onLine) {
             console.log('Offline - sync skipped');
@@ -232,38 +300,74 @@ const SyncManager = {
         }
 
         const pending = await OfflineStore.getPendingCheckins();
-        if (pending.length === 0) {
+        const pendingIncidents = await OfflineStore.getPendingIncidents();
+        if (pending.length === 0 && pendingIncidents.length === 0) {
             return { synced: 0, failed: 0 };
         }
 
-        console.log(`Syncing ${pending.length} pending check-ins...`);
+        console.log(`Syncing ${pending.length} pending check-ins and ${pendingIncidents.length} incidents...`);
 
         try {
+            const deviceId = this.deviceId();
+            // Entries queued before client IDs existed get a stable one from their local key
+            const clientIdFor = p => p.clientId || `${deviceId}-${p.id}`;
+
             const checkins = pending.map(p => ({
+                client_id: clientIdFor(p),
                 hike_id: p.hikeId,
                 membership_number: p.membershipNumber,
+                checked_in_at: p.timestamp,
+                manual: !!p.manual,
             }));
 
-            const result = await API.bulkCheckin(checkins);
+            const incidents = pendingIncidents.map(({ id, ...incident }) => incident);
+
+            const result = await API.bulkCheckin(checkins, deviceId, incidents);
 
-            // Clear successfully synced
-            const syncedIds = pending.map(p => p.id);
-            await OfflineStore.clearPendingCheckins(syncedIds);
+            // Clear every entry the server gave a final answer for; "error" items are retried
+            const statuses = new Map((result.results || []).map(r => [r.client_id, r]));
+            const doneIds = [];
+            const failures = [];
+            for (const p of pending) {
+                const r = statuses.get(clientIdFor(p));
+                if (!r || r.status === 'error') continue;
+                doneIds.push(p.id);
+                if (r.status !== 'created' && r.status !== 'duplicate') {
+                    failures.push(r);
+                }
+            }
+            await OfflineStore.clearPendingCheckins(doneIds);
+
+            const incidentStatuses = new Map((result.incident_results || []).map(r => [r.client_id, r]));
+            const doneIncidentIds = [];
+            for (const p of pendingIncidents) {
+                const r = incidentStatuses.get(p.client_id);
+                if (!r || r.status === 'error') continue;
+                doneIncidentIds.push(p.id);
+                if (r.status !== 'created' && r.status !== 'duplicate') {
+                    failures.push(r);
+                }
+            }
+            await OfflineStore.clearPendingIncidents(doneIncidentIds);
 
-            const synced = result.checkins?.length || 0;
-            const failed = result.errors?.length || 0;
+            const synced = (result.results || []).filter(r => r.status === 'created').length;
+            const syncedIncidents = (result.incident_results || []).filter(r => r.status === 'created').length;
+            const failed = pending.length - doneIds.length + pendingIncidents.length - doneIncidentIds.length + failures.length;
 
             if (synced > 0) {
                 Toast.show(`Synced ${synced} check-in${synced > 1 ? 's' : ''}`, 'success');
             }
-            if (failed > 0) {
-                console.error('Sync errors:', result.errors);
+            if (syncedIncidents > 0) {
+                Toast.show(`Synced ${syncedIncidents} incident${syncedIncidents > 1 ? 's' : ''}`, 'success');
+            }
+            if (failures.length > 0) {
+                console.error('Sync rejected:', failures);
             }
 
             return { synced, failed };
         } catch (err) {
             console.error('Sync failed:', err);
-            return { synced: 0, failed: pending.length };
+            return { synced: 0, failed: pending.length + pendingIncidents.length };
         }
     },
 
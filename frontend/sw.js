@@ -64,8 +64,8 @@ self.addEventListener('fetch', (event) => {
         return;
     }
 
-    // RSVP pages - network only (public pages, should always be fresh)
-    if (url.pathname.startsWith('/rsvp/')) {
+    // RSVP and wallet pages - network only (public pages, should always be fresh)
+    if (url.pathname.startsWith('/rsvp/') || url.pathname.startsWith('/wallet/')) {
         event.respondWith(
             fetch(event.request).catch(() => {
                 return new Response(
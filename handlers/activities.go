@@ -16,6 +16,9 @@ func HandleActivities(w http.ResponseWriter, r *http.Request, hikeID int64) {
 	case http.MethodGet:
 		listActivities(w, r, hikeID)
 	case http.MethodPost:
+		if !requirePermission(w, r, PermManageActivities) {
+			return
+		}
 		createActivity(w, r, hikeID)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -51,10 +54,15 @@ func createActivity(w http.ResponseWriter, r *http.Request, hikeID int64) {
 
 	activity, err := db.CreateActivity(hikeID, req.Name)
 	if err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, "create", "activity", activity.ID, nil, activity)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(activity)
@@ -89,6 +97,9 @@ func HandleActivity(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		getActivity(w, r, activityID)
 	case http.MethodDelete:
+		if !requirePermission(w, r, PermManageActivities) {
+			return
+		}
 		deleteActivity(w, r, activityID)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -106,14 +117,29 @@ func getActivity(w http.ResponseWriter, r *http.Request, activityID int64) {
 }
 
 func deleteActivity(w http.ResponseWriter, r *http.Request, activityID int64) {
+	before, err := db.GetActivityByID(activityID)
+	if err != nil {
+		http.Error(w, "Activity not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.DeleteActivity(activityID); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	recordAudit(r, "delete", "activity", activityID, before, nil)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func handleActivityParticipants(w http.ResponseWriter, r *http.Request, activityID int64) {
+	if r.Method != http.MethodGet && !requirePermission(w, r, PermManageActivities) {
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		participants, err := db.GetActivityParticipants(activityID)
@@ -143,10 +169,15 @@ func handleActivityParticipants(w http.ResponseWriter, r *http.Request, activity
 		}
 
 		if err := db.AddActivityParticipant(activityID, req.CheckinID, req.RSVPID); err != nil {
+			if writeHikeClosed(w, err) {
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		recordAudit(r, "add_participant", "activity", activityID, nil, req)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
@@ -161,10 +192,15 @@ func handleActivityParticipants(w http.ResponseWriter, r *http.Request, activity
 		}
 
 		if err := db.RemoveActivityParticipant(activityID, req.CheckinID, req.RSVPID); err != nil {
+			if writeHikeClosed(w, err) {
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		recordAudit(r, "remove_participant", "activity", activityID, req, nil)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
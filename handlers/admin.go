@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"trailcall/db"
+)
+
+// HandleBackup streams a fresh copy of the database as a download
+func HandleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path, err := db.BackupToTemp()
+	if err != nil {
+		http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	recordAudit(r, "backup", "database", 0, nil, nil)
+
+	filename := "trailcall-" + time.Now().Format("20060102-150405") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	http.ServeContent(w, r, filename, time.Now(), f)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// HandleHikeAlerts handles /api/hikes/{id}/alerts: GET lists the hike's
+// alerts, POST raises an overdue alert
+func HandleHikeAlerts(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		listAlerts(w, hikeID, r.URL.Query().Get("status"))
+	case http.MethodPost:
+		if !requirePermission(w, r, PermCheckin) {
+			return
+		}
+		raiseAlert(w, r, hikeID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleEmergencySheet handles GET /api/hikes/{id}/emergency-sheet with
+// optional format=csv. The sheet reflects the hike as it is right now.
+func HandleEmergencySheet(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sheet, err := db.GetEmergencySheet(hikeID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Hike not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		exportEmergencySheetCSV(w, sheet, fmt.Sprintf("emergency_sheet_hike_%d.csv", hikeID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sheet)
+}
+
+// HandleAlerts handles /api/alerts, /api/alerts/{id} and /api/alerts/{id}/resolve
+func HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/alerts"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listAlerts(w, 0, r.URL.Query().Get("status"))
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid alert ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) >= 2 && parts[1] == "resolve" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requirePermission(w, r, PermManageHikes) {
+			return
+		}
+		resolveAlert(w, r, id)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	getAlert(w, r, id)
+}
+
+func listAlerts(w http.ResponseWriter, hikeID int64, status string) {
+	alerts, err := db.GetAlerts(hikeID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if alerts == nil {
+		alerts = []models.HikeAlert{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func raiseAlert(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	// The body is optional
+	var req models.RaiseAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sheet, err := db.GetEmergencySheet(hikeID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Hike not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user := CurrentUser(r)
+	alert, err := db.CreateAlert(hikeID, models.AlertOverdue, strings.TrimSpace(req.Notes), user.ID, sheet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("OVERDUE alert %d raised by %s for hike %d (%s): %d of %d still out",
+		alert.ID, user.Username, hikeID, sheet.HikeName, len(sheet.Outstanding), sheet.Started)
+	recordAudit(r, "raise", "alert", alert.ID, nil, alert)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(alert)
+}
+
+func getAlert(w http.ResponseWriter, r *http.Request, id int64) {
+	alert, err := db.GetAlertByID(id)
+	if err != nil {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" && alert.Sheet != nil {
+		exportEmergencySheetCSV(w, alert.Sheet, fmt.Sprintf("alert_%d_emergency_sheet.csv", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alert)
+}
+
+func resolveAlert(w http.ResponseWriter, r *http.Request, id int64) {
+	var req models.ResolveAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Notes = strings.TrimSpace(req.Notes)
+	if req.Notes == "" {
+		http.Error(w, "notes are required", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetAlertByID(id)
+	if err != nil {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+
+	alert, err := db.ResolveAlert(id, CurrentUser(r).ID, req.Notes)
+	if err != nil {
+		if errors.Is(err, db.ErrAlertResolved) {
+			writeJSONError(w, "Alert is already resolved", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The sheet doesn't change on resolution, so keep it out of the audit entry
+	before.Sheet = nil
+	after := *alert
+	after.Sheet = nil
+	recordAudit(r, "resolve", "alert", id, before, after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alert)
+}
+
+func exportEmergencySheetCSV(w http.ResponseWriter, sheet *models.EmergencySheet, filename string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	writer.Write([]string{"Hike", sheet.HikeName})
+	writer.Write([]string{"Date", sheet.HikeDate})
+	writer.Write([]string{"Location", sheet.Location})
+	writer.Write([]string{"Generated", sheet.GeneratedAt.Format("2006-01-02 15:04:05")})
+	writer.Write([]string{"Started", strconv.Itoa(sheet.Started)})
+	writer.Write([]string{"Outstanding", strconv.Itoa(len(sheet.Outstanding))})
+	writer.Write([]string{})
+
+	writer.Write([]string{"Group", "Name", "Membership Number", "Phone", "Checked In", "Checked Out"})
+	write := func(group string, people []models.EmergencySheetPerson) {
+		for _, p := range people {
+			number := p.MembershipNumber
+			if p.IsGuest {
+				number = "Guest"
+			}
+			checkedOut := ""
+			if p.CheckedOutAt != nil {
+				checkedOut = p.CheckedOutAt.Format("15:04")
+			}
+			writer.Write([]string{group, p.Name, number, p.Phone, p.CheckedInAt.Format("15:04"), checkedOut})
+		}
+	}
+	write("Leader", sheet.Leaders)
+	write("Sweeper", sheet.Sweepers)
+	write("Outstanding", sheet.Outstanding)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// recordAudit logs a mutating action by the request's authenticated user.
+// Failures are logged rather than failing the request that already succeeded.
+func recordAudit(r *http.Request, action, entityType string, entityID int64, before, after interface{}) {
+	recordAuditAs(CurrentUser(r), action, entityType, entityID, before, after)
+}
+
+// recordAuditAs logs an action on behalf of user; a nil user is recorded as "public"
+func recordAuditAs(user *models.User, action, entityType string, entityID int64, before, after interface{}) {
+	entry := models.AuditEntry{
+		Actor:      "public",
+		Action:     action,
+		EntityType: entityType,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+	}
+	if user != nil {
+		entry.UserID = &user.ID
+		entry.Actor = user.Username
+	}
+	if entityID != 0 {
+		entry.EntityID = &entityID
+	}
+
+	if err := db.CreateAuditEntry(entry); err != nil {
+		log.Printf("Failed to write audit entry (%s %s %d): %v", action, entityType, entityID, err)
+	}
+}
+
+func auditJSON(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil
+	}
+	return b
+}
+
+// HandleAudit handles GET /api/audit with optional entity_type, entity_id,
+// from, to (YYYY-MM-DD), limit and format=csv query parameters
+func HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		EntityType: q.Get("entity_type"),
+		From:       q.Get("from"),
+		To:         q.Get("to"),
+	}
+	if idStr := q.Get("entity_id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid entity_id", http.StatusBadRequest)
+			return
+		}
+		filter.EntityID = &id
+	}
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := db.GetAuditEntries(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if q.Get("format") == "csv" {
+		exportAuditCSV(w, entries)
+		return
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func exportAuditCSV(w http.ResponseWriter, entries []models.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit_log.csv\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	writer.Write([]string{"Time", "Actor", "Action", "Entity Type", "Entity ID", "Before", "After"})
+
+	// Data
+	for _, e := range entries {
+		entityID := ""
+		if e.EntityID != nil {
+			entityID = strconv.FormatInt(*e.EntityID, 10)
+		}
+		writer.Write([]string{
+			e.CreatedAt.Format("2006-01-02 15:04:05"),
+			e.Actor,
+			e.Action,
+			e.EntityType,
+			entityID,
+			string(e.Before),
+			string(e.After),
+		})
+	}
+}
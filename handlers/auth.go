@@ -1,29 +1,34 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
-	"os"
-	"sync"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"trailcall/db"
 	"trailcall/models"
 )
 
-var (
-	sessions   = make(map[string]time.Time)
-	sessionsMu sync.RWMutex
+type contextKey string
+
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "api_token"
 )
 
 const sessionDuration = 24 * time.Hour
 
-func getHashedPIN() string {
-	return os.Getenv("TRAILCALL_PIN_HASH")
-}
+// dummyHash is compared against when the username is unknown so that
+// failed logins take the same time whether or not the user exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("trailcall"), bcrypt.DefaultCost)
 
 func generateSessionToken() string {
 	b := make([]byte, 32)
@@ -31,33 +36,86 @@ func generateSessionToken() string {
 	return hex.EncodeToString(b)
 }
 
+// hashToken is what gets stored in the database in place of the raw token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSessionSweeper periodically deletes expired sessions
+func StartSessionSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := db.DeleteExpiredSessions()
+			if err != nil {
+				log.Println("Failed to sweep expired sessions:", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Removed %d expired sessions", removed)
+			}
+		}
+	}()
+}
+
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	ip := clientIP(r)
+	if wait := limiter.retryAfter(ip); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
 	var req models.AuthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	hashedPIN := getHashedPIN()
-	if hashedPIN == "" {
-		http.Error(w, "PIN not configured", http.StatusInternalServerError)
+	if req.Username == "" || req.PIN == "" {
+		http.Error(w, "username and pin are required", http.StatusBadRequest)
+		return
+	}
+	if wait := limiter.retryAfterUsername(req.Username); wait > 0 {
+		writeTooManyAttempts(w, wait)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPIN), []byte(req.PIN)); err != nil {
-		http.Error(w, "Invalid PIN", http.StatusUnauthorized)
+	if delay := limiter.globalDelay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	user, err := db.GetUserByUsername(req.Username)
+	if err != nil || !user.Active {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(req.PIN))
+		limiter.recordFailure(ip, req.Username, err == nil)
+		http.Error(w, "Invalid username or PIN", http.StatusUnauthorized)
 		return
 	}
 
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PINHash), []byte(req.PIN)); err != nil {
+		limiter.recordFailure(ip, req.Username, true)
+		http.Error(w, "Invalid username or PIN", http.StatusUnauthorized)
+		return
+	}
+	limiter.recordSuccess(ip, req.Username)
+	recordAuditAs(user, "login", "user", user.ID, nil, nil)
+
 	token := generateSessionToken()
-	sessionsMu.Lock()
-	sessions[token] = time.Now().Add(sessionDuration)
-	sessionsMu.Unlock()
+	if err := db.CreateSession(hashToken(token), user.ID, time.Now().Add(sessionDuration)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session",
@@ -69,61 +127,128 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "user": user})
 }
 
 func HandleLogout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session")
 	if err == nil {
-		sessionsMu.Lock()
-		delete(sessions, cookie.Value)
-		sessionsMu.Unlock()
+		if userID, _, err := db.GetSession(hashToken(cookie.Value)); err == nil {
+			user, _ := db.GetUserByID(userID)
+			recordAuditAs(user, "logout", "user", userID, nil, nil)
+		}
+		db.DeleteSession(hashToken(cookie.Value))
+	}
+
+	clearSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HandleLogoutAll signs the current user out on every device
+func HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if CurrentAPIToken(r) != nil {
+		writeJSONError(w, "API tokens cannot sign out sessions", http.StatusForbidden)
+		return
+	}
+
+	user := CurrentUser(r)
+	removed, err := db.DeleteSessionsForUser(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	recordAudit(r, "logout_all", "user", user.ID, nil, map[string]int64{"sessions_removed": removed})
+	clearSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "sessions_removed": removed})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:   "session",
 		Value:  "",
 		Path:   "/",
 		MaxAge: -1,
 	})
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
 func HandleCheckAuth(w http.ResponseWriter, r *http.Request) {
-	if isAuthenticated(r) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]bool{"authenticated": true})
+	user, _ := authenticate(r)
+	w.Header().Set("Content-Type", "application/json")
+	if user != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"authenticated": true, "user": user})
 	} else {
-		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]bool{"authenticated": false})
 	}
 }
 
-func isAuthenticated(r *http.Request) bool {
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		return false
-	}
+// authenticate returns the active user for the request, or nil. Requests
+// carrying an "Authorization: Bearer" API token also return that token.
+func authenticate(r *http.Request) (*models.User, *models.APIToken) {
+	var userID int64
+	var token *models.APIToken
 
-	sessionsMu.RLock()
-	expiry, exists := sessions[cookie.Value]
-	sessionsMu.RUnlock()
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		t, err := db.GetActiveAPIToken(hashToken(strings.TrimSpace(bearer)))
+		if err != nil {
+			return nil, nil
+		}
+		db.TouchAPIToken(t.ID)
+		userID, token = t.UserID, t
+	} else {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			return nil, nil
+		}
 
-	if !exists || time.Now().After(expiry) {
-		return false
+		id, expiresAt, err := db.GetSession(hashToken(cookie.Value))
+		if err != nil || time.Now().After(expiresAt) {
+			return nil, nil
+		}
+		userID = id
 	}
-	return true
+
+	// Look the user up on every request so disabling an account takes effect immediately
+	user, err := db.GetUserByID(userID)
+	if err != nil || !user.Active {
+		return nil, nil
+	}
+	return user, token
+}
+
+// CurrentUser returns the user attached to the request by AuthMiddleware
+func CurrentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// CurrentAPIToken returns the API token used for the request, or nil for cookie sessions
+func CurrentAPIToken(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*models.APIToken)
+	return token
 }
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !isAuthenticated(r) {
+		user, token := authenticate(r)
+		if user == nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		if token != nil {
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Member codes can be drawn as a QR code, a Code 128 barcode or a Data
+// Matrix, as PNG or SVG. The options come from the query string of
+// GET /api/members/{id}/qr:
+//
+//	format  qr (default), code128 or datamatrix
+//	size    width in pixels, 64 to 2048 (default 256)
+//	ec      QR error correction level: L, M (default), Q or H
+//	output  png (default) or svg
+
+const (
+	defaultBarcodeSize = 256
+	minBarcodeSize     = 64
+	maxBarcodeSize     = 2048
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+type barcodeOptions struct {
+	format string
+	size   int
+	level  qrcode.RecoveryLevel
+	svg    bool
+}
+
+func parseBarcodeOptions(q url.Values) (barcodeOptions, error) {
+	opts := barcodeOptions{format: "qr", size: defaultBarcodeSize, level: qrcode.Medium}
+
+	switch format := q.Get("format"); format {
+	case "", "qr":
+	case "code128", "datamatrix":
+		opts.format = format
+	default:
+		return opts, errors.New("format must be qr, code128 or datamatrix")
+	}
+
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < minBarcodeSize || size > maxBarcodeSize {
+			return opts, fmt.Errorf("size must be between %d and %d", minBarcodeSize, maxBarcodeSize)
+		}
+		opts.size = size
+	}
+
+	if v := q.Get("ec"); v != "" {
+		if opts.format != "qr" {
+			return opts, errors.New("ec only applies to QR codes")
+		}
+		level, ok := qrLevels[strings.ToUpper(v)]
+		if !ok {
+			return opts, errors.New("ec must be L, M, Q or H")
+		}
+		opts.level = level
+	}
+
+	switch q.Get("output") {
+	case "", "png":
+	case "svg":
+		opts.svg = true
+	default:
+		return opts, errors.New("output must be png or svg")
+	}
+	return opts, nil
+}
+
+// barcode is a drawn code: rows of modules, true for dark
+type barcode struct {
+	rows  [][]bool
+	quiet int // light modules required around the code
+	// A linear code has one row, drawn as bars a third as tall as the code is wide
+	linear bool
+}
+
+func encodeBarcode(content string, opts barcodeOptions) (*barcode, error) {
+	switch opts.format {
+	case "code128":
+		bars, err := encodeCode128(content)
+		if err != nil {
+			return nil, err
+		}
+		return &barcode{rows: [][]bool{bars}, quiet: 10, linear: true}, nil
+	case "datamatrix":
+		rows, err := encodeDataMatrix(content)
+		if err != nil {
+			return nil, err
+		}
+		return &barcode{rows: rows, quiet: 1}, nil
+	default:
+		code, err := qrcode.New(content, opts.level)
+		if err != nil {
+			return nil, err
+		}
+		code.DisableBorder = true
+		return &barcode{rows: code.Bitmap(), quiet: 4}, nil
+	}
+}
+
+// modules returns the width and height of the code in modules, quiet zone included
+func (b *barcode) modules() (int, int) {
+	w := len(b.rows[0]) + 2*b.quiet
+	if b.linear {
+		return w, w / 3
+	}
+	return w, len(b.rows) + 2*b.quiet
+}
+
+func (b *barcode) dark(x, y int) bool {
+	x -= b.quiet
+	if x < 0 || x >= len(b.rows[0]) {
+		return false
+	}
+	if b.linear {
+		return b.rows[0][x]
+	}
+	y -= b.quiet
+	if y < 0 || y >= len(b.rows) {
+		return false
+	}
+	return b.rows[y][x]
+}
+
+// png draws the code size pixels wide, using whole pixels per module so
+// edges stay sharp. Codes with more modules than pixels come out wider.
+func (b *barcode) png(size int) ([]byte, error) {
+	w, h := b.modules()
+	scale := size / w
+	if scale < 1 {
+		scale = 1
+	}
+	imgW := size
+	if w*scale > imgW {
+		imgW = w * scale
+	}
+	imgH := imgW
+	if b.linear {
+		imgH = h * scale
+	}
+	offsetX, offsetY := (imgW-w*scale)/2, (imgH-h*scale)/2
+
+	img := image.NewGray(image.Rect(0, 0, imgW, imgH))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for my := 0; my < h; my++ {
+		for mx := 0; mx < w; mx++ {
+			if !b.dark(mx, my) {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetGray(offsetX+mx*scale+px, offsetY+my*scale+py, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// svg draws the code size pixels wide with one rectangle per run of dark modules
+func (b *barcode) svg(size int) []byte {
+	w, h := b.modules()
+	var path strings.Builder
+	rows, rowHeight := len(b.rows), 1
+	if b.linear {
+		rows, rowHeight = 1, h
+	}
+	for y := 0; y < rows; y++ {
+		line := b.rows[y]
+		for x := 0; x < len(line); {
+			if !line[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(line) && line[x] {
+				x++
+			}
+			top := y * rowHeight
+			if !b.linear {
+				top += b.quiet
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv%dh-%dz", start+b.quiet, top, x-start, rowHeight, x-start)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size*h/w, w, h)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`, w, h, path.String())
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// writeCardError sends a 403 and returns true if err is a rejected card
+func writeCardError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errManualCheckin):
+		writeJSONError(w, "Forbidden: requires "+string(PermManualCheckin)+" permission", http.StatusForbidden)
+	case errors.Is(err, db.ErrCardRevoked):
+		writeJSONError(w, "This card has been replaced. Ask the member for their new card", http.StatusForbidden)
+	case errors.Is(err, db.ErrInvalidCard):
+		writeJSONError(w, "Card could not be verified", http.StatusForbidden)
+	case errors.Is(err, db.ErrPlainCard):
+		writeJSONError(w, "Old-style card without a signature. Print the member a new card", http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+// revokeCard handles POST /api/members/{id}/revoke-card. Cards printed
+// before this stop working; the member needs a newly printed card.
+func revokeCard(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	before, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	member, err := db.RevokeCard(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "revoke_card", "member", id, before, member)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// Card sheets are laid out as ID-1 (credit card) sized cards, two across and
+// five down on A4, butted together so one cut along each crop mark separates them
+const (
+	cardWidthMM   = 85.6
+	cardHeightMM  = 53.98
+	cardColumns   = 2
+	cardRows      = 5
+	cardPaddingMM = 4
+	cardQRSizeMM  = 34
+)
+
+// clubName is printed on membership cards, from TRAILCALL_CLUB_NAME
+func clubName() string {
+	if name := os.Getenv("TRAILCALL_CLUB_NAME"); name != "" {
+		return name
+	}
+	return "Centurion Hiking Club"
+}
+
+// HandleMemberCardsPDF handles GET /api/members/cards.pdf?ids=1,2,3. Without
+// ids every active member gets a card. The cards carry signed codes, so
+// printing them needs PermEditMembers.
+func HandleMemberCardsPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	var members []models.Member
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		for _, s := range strings.Split(ids, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid member ID: "+s, http.StatusBadRequest)
+				return
+			}
+			member, err := db.GetMemberByID(id)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Member %d not found", id), http.StatusNotFound)
+				return
+			}
+			members = append(members, *member)
+		}
+	} else {
+		var err error
+		members, err = db.GetAllMembers(true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(members) == 0 {
+		http.Error(w, "No members to print", http.StatusNotFound)
+		return
+	}
+
+	doc, err := buildCardSheets(members, clubName())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\"membership_cards.pdf\"")
+	doc.writeTo(w)
+}
+
+func buildCardSheets(members []models.Member, club string) (*pdfDocument, error) {
+	doc := &pdfDocument{}
+	perPage := cardColumns * cardRows
+
+	gridWidth := mm(cardWidthMM * cardColumns)
+	gridHeight := mm(cardHeightMM * cardRows)
+	left := (pdfPageWidth - gridWidth) / 2
+	bottom := (pdfPageHeight - gridHeight) / 2
+
+	var page *pdfPage
+	for i := range members {
+		slot := i % perPage
+		if slot == 0 {
+			page = doc.addPage()
+			drawCropMarks(page, left, bottom, gridWidth, gridHeight)
+		}
+		// Fill each page top to bottom, left to right
+		col := slot % cardColumns
+		row := cardRows - 1 - slot/cardColumns
+		x := left + float64(col)*mm(cardWidthMM)
+		y := bottom + float64(row)*mm(cardHeightMM)
+		if err := drawCard(page, x, y, &members[i], club); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// drawCropMarks marks every cut line in the margin around the grid of cards
+func drawCropMarks(page *pdfPage, left, bottom, width, height float64) {
+	gap, length := mm(2), mm(6)
+	for c := 0; c <= cardColumns; c++ {
+		x := left + float64(c)*mm(cardWidthMM)
+		page.line(x, bottom-gap, x, bottom-gap-length, 0.3)
+		page.line(x, bottom+height+gap, x, bottom+height+gap+length, 0.3)
+	}
+	for r := 0; r <= cardRows; r++ {
+		y := bottom + float64(r)*mm(cardHeightMM)
+		page.line(left-gap, y, left-gap-length, y, 0.3)
+		page.line(left+width+gap, y, left+width+gap+length, y, 0.3)
+	}
+}
+
+// drawCard lays out one card with its bottom-left corner at x, y: the club
+// name, member name and number on the left and the QR code on the right
+func drawCard(page *pdfPage, x, y float64, member *models.Member, club string) error {
+	code, err := qrcode.New(db.CardPayload(member), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	// The bitmap keeps the 4-module quiet zone scanners need, since cards
+	// are printed edge to edge with little white space around the code
+	bitmap := code.Bitmap()
+
+	padding := mm(cardPaddingMM)
+	qrSize := mm(cardQRSizeMM)
+	qrX := x + mm(cardWidthMM) - padding - qrSize
+	qrY := y + (mm(cardHeightMM)-qrSize)/2
+	module := qrSize / float64(len(bitmap))
+	for row, line := range bitmap {
+		// One rectangle per run of dark modules keeps the page small
+		for col := 0; col < len(line); {
+			if !line[col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(line) && line[col] {
+				col++
+			}
+			page.fillRect(qrX+float64(start)*module, qrY+qrSize-float64(row+1)*module, float64(col-start)*module, module)
+		}
+	}
+	page.fill()
+
+	textX := x + padding
+	maxWidth := qrX - textX - mm(3)
+	top := y + mm(cardHeightMM) - padding
+
+	page.text(true, fitText(true, 9, 5, maxWidth, club), textX, top-9, club)
+
+	firstSize := fitText(true, 14, 7, maxWidth, member.FirstName)
+	page.text(true, firstSize, textX, top-mm(17), member.FirstName)
+	lastSize := fitText(true, 14, 7, maxWidth, member.LastName)
+	page.text(true, lastSize, textX, top-mm(17)-lastSize-2, member.LastName)
+
+	page.text(false, fitText(false, 11, 6, maxWidth, member.MembershipNumber), textX, y+padding+2, member.MembershipNumber)
+	return nil
+}
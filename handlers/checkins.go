@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"trailcall/db"
 	"trailcall/models"
@@ -13,16 +17,35 @@ import (
 func HandleCheckins(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/checkins")
 
+	if !requirePermission(w, r, PermCheckin) {
+		return
+	}
+
 	if path == "/bulk" || path == "/bulk/" {
 		handleBulkCheckin(w, r)
 		return
 	}
 
+	if path == "/checkout" || path == "/checkout/" {
+		handleScanCheckout(w, r)
+		return
+	}
+
+	if path == "/household" || path == "/household/" {
+		handleHouseholdCheckin(w, r)
+		return
+	}
+
 	if strings.HasSuffix(path, "/role") {
 		HandleCheckinRole(w, r)
 		return
 	}
 
+	if strings.HasSuffix(path, "/checkout") {
+		HandleCheckinCheckout(w, r)
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -31,6 +54,29 @@ func HandleCheckins(w http.ResponseWriter, r *http.Request) {
 	createCheckin(w, r)
 }
 
+// errManualCheckin is returned when a check-in claims manual entry without
+// PermManualCheckin
+var errManualCheckin = errors.New("manual check-in not permitted")
+
+// resolveCheckinCode resolves a scanned code to a membership number. Manual
+// entry, where a leader picked the member instead of scanning a card, skips
+// the card checks, so it is only honoured for users with PermManualCheckin.
+func resolveCheckinCode(r *http.Request, code string, manual bool) (string, error) {
+	if manual && !hasPermission(r, PermManualCheckin) {
+		return "", errManualCheckin
+	}
+	return db.ResolveCardCode(code, manual)
+}
+
+// checkinAction is the audit action for a check-in, keeping manual entries
+// apart from card scans
+func checkinAction(manual bool) string {
+	if manual {
+		return "manual_checkin"
+	}
+	return "checkin"
+}
+
 func createCheckin(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateCheckinRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -43,8 +89,12 @@ func createCheckin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	checkin, err := db.CreateCheckin(req.HikeID, req.MembershipNumber)
+	// The scanned code is either a signed card payload or a plain membership number
+	membershipNumber, err := resolveCheckinCode(r, req.MembershipNumber, req.Manual)
 	if err != nil {
+		if writeCardError(w, err) {
+			return
+		}
 		if strings.Contains(err.Error(), "no rows") {
 			http.Error(w, "Member not found", http.StatusNotFound)
 			return
@@ -53,11 +103,54 @@ func createCheckin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	checkin, err := db.CreateCheckin(req.HikeID, membershipNumber)
+	if err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, checkinAction(req.Manual), "checkin", checkin.ID, nil, checkin)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(checkin)
 }
 
+// Offline scan times are trusted only within this slack around the hike's
+// date, and never more than clockSkew ahead of the server clock
+const (
+	scanWindowSlack = 24 * time.Hour
+	clockSkew       = 5 * time.Minute
+)
+
+// checkScanTime rejects offline timestamps that can't belong to the hike
+func checkScanTime(hike *models.Hike, scannedAt time.Time) error {
+	if scannedAt.After(time.Now().Add(clockSkew)) {
+		return fmt.Errorf("checked_in_at %s is in the future", scannedAt.UTC().Format(time.RFC3339))
+	}
+	day, err := time.Parse("2006-01-02", hike.Date)
+	if err != nil {
+		return nil
+	}
+	from := day.Add(-scanWindowSlack)
+	to := day.Add(24*time.Hour + scanWindowSlack)
+	if scannedAt.Before(from) || !scannedAt.Before(to) {
+		return fmt.Errorf("checked_in_at %s is outside the window for hike on %s", scannedAt.UTC().Format(time.RFC3339), hike.Date)
+	}
+	return nil
+}
+
+// handleBulkCheckin uploads a device's offline queue. Each item gets its own
+// result so the device knows exactly which entries it can clear; items with
+// a client_id that has been seen before are reported as duplicates. Queued
+// incidents are stored after the check-ins, with results in incident_results.
 func handleBulkCheckin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -70,32 +163,140 @@ func handleBulkCheckin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var results []models.Checkin
-	var errors []string
+	results := make([]models.BulkCheckinResult, 0, len(req.Checkins))
+	var offlineIDs []int64
+	hikes := make(map[int64]*models.Hike)
 
 	for _, c := range req.Checkins {
-		checkin, err := db.CreateCheckin(c.HikeID, c.MembershipNumber)
-		if err != nil {
-			errors = append(errors, c.MembershipNumber+": "+err.Error())
-			continue
+		result := bulkCheckinItem(r, c, req.DeviceID, hikes)
+		if result.Checkin != nil && !result.Checkin.Synced {
+			offlineIDs = append(offlineIDs, result.Checkin.ID)
 		}
-		results = append(results, *checkin)
+		results = append(results, result)
+	}
+
+	// The batch has been stored, so the offline rows can be flagged as synced
+	if err := db.MarkCheckinsSynced(offlineIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	response := map[string]interface{}{
-		"checkins": results,
-		"errors":   errors,
+	incidentResults := make([]models.BulkIncidentResult, 0, len(req.Incidents))
+	for _, inc := range req.Incidents {
+		incidentResults = append(incidentResults, bulkIncidentItem(r, inc, req.DeviceID, hikes))
+	}
+
+	// checkins and errors are kept for clients that predate per-item results
+	var checkins []models.Checkin
+	var failures []string
+	for i := range results {
+		res := &results[i]
+		if res.Checkin != nil && !res.Checkin.Synced {
+			if c, err := db.GetCheckinByID(res.Checkin.ID); err == nil {
+				res.Checkin = c
+			}
+		}
+		if res.Status == models.BulkStatusCreated {
+			checkins = append(checkins, *res.Checkin)
+		} else if res.Error != "" {
+			failures = append(failures, res.MembershipNumber+": "+res.Error)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if len(errors) > 0 && len(results) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-	} else if len(errors) > 0 {
-		w.WriteHeader(http.StatusPartialContent)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":          results,
+		"incident_results": incidentResults,
+		"checkins":         checkins,
+		"errors":           failures,
+	})
+}
+
+func bulkCheckinItem(r *http.Request, c models.CreateCheckinRequest, deviceID string, hikes map[int64]*models.Hike) models.BulkCheckinResult {
+	result := models.BulkCheckinResult{ClientID: c.ClientID, MembershipNumber: c.MembershipNumber}
+	fail := func(status, message string) models.BulkCheckinResult {
+		result.Status = status
+		result.Error = message
+		return result
+	}
+
+	if c.ClientID != "" {
+		checkinID, found, err := db.GetCheckinForClientID(c.ClientID)
+		if err != nil {
+			return fail(models.BulkStatusError, err.Error())
+		}
+		if found {
+			result.Status = models.BulkStatusDuplicate
+			if checkinID != nil {
+				result.Checkin, _ = db.GetCheckinByID(*checkinID)
+			}
+			return result
+		}
+	}
+
+	if c.HikeID == 0 || c.MembershipNumber == "" {
+		return fail(models.BulkStatusInvalid, "hike_id and membership_number are required")
+	}
+
+	hike, ok := hikes[c.HikeID]
+	if !ok {
+		h, err := db.GetHikeByID(c.HikeID)
+		if err != nil {
+			if strings.Contains(err.Error(), "no rows") {
+				return fail(models.BulkStatusHikeNotFound, "hike not found")
+			}
+			return fail(models.BulkStatusError, err.Error())
+		}
+		hike = h
+		hikes[c.HikeID] = hike
+	}
+	if hike.Status == "closed" {
+		return fail(models.BulkStatusHikeClosed, "hike is closed")
+	}
+	if c.CheckedInAt != nil {
+		if err := checkScanTime(hike, *c.CheckedInAt); err != nil {
+			return fail(models.BulkStatusInvalid, err.Error())
+		}
+	}
+
+	membershipNumber, err := resolveCheckinCode(r, c.MembershipNumber, c.Manual)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCard) || errors.Is(err, db.ErrCardRevoked) || errors.Is(err, db.ErrPlainCard) ||
+			errors.Is(err, errManualCheckin) {
+			return fail(models.BulkStatusCardRejected, err.Error())
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			return fail(models.BulkStatusMemberNotFound, "member not found")
+		}
+		return fail(models.BulkStatusError, err.Error())
+	}
+	result.MembershipNumber = membershipNumber
+
+	checkin, created, err := db.CreateOfflineCheckin(c.HikeID, membershipNumber, c.CheckedInAt, deviceID)
+	if err != nil {
+		if errors.Is(err, db.ErrHikeClosed) {
+			return fail(models.BulkStatusHikeClosed, "hike is closed")
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			return fail(models.BulkStatusMemberNotFound, "member not found")
+		}
+		return fail(models.BulkStatusError, err.Error())
+	}
+
+	result.Checkin = checkin
+	if created {
+		result.Status = models.BulkStatusCreated
+		recordAudit(r, checkinAction(c.Manual), "checkin", checkin.ID, nil, checkin)
 	} else {
-		w.WriteHeader(http.StatusCreated)
+		result.Status = models.BulkStatusDuplicate
 	}
-	json.NewEncoder(w).Encode(response)
+
+	if c.ClientID != "" {
+		if err := db.SaveCheckinClientID(c.ClientID, checkin.ID); err != nil {
+			log.Printf("Failed to record check-in client ID %s: %v", c.ClientID, err)
+		}
+	}
+	return result
 }
 
 // HandleCheckinRole toggles a role for a check-in
@@ -129,10 +330,121 @@ func HandleCheckinRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before, err := db.GetCheckinByID(checkinID)
+	if err != nil {
+		http.Error(w, "Check-in not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.UpdateCheckinRole(checkinID, req.Role, req.Value); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	after, _ := db.GetCheckinByID(checkinID)
+	recordAudit(r, "set_role", "checkin", checkinID, before, after)
+
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// handleScanCheckout checks a member out by membership number when their
+// card is scanned again at the end of the hike
+func handleScanCheckout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.HikeID == 0 || req.MembershipNumber == "" {
+		http.Error(w, "hike_id and membership_number are required", http.StatusBadRequest)
+		return
+	}
+
+	membershipNumber, err := db.ResolveCardCode(req.MembershipNumber, false)
+	if err != nil {
+		if writeCardError(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	checkin, err := db.CheckoutMember(req.HikeID, membershipNumber, db.CheckoutScan)
+	if err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrNotCheckedIn) {
+			writeJSONError(w, "Member is not checked in to this hike", http.StatusNotFound)
+			return
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "checkout", "checkin", checkin.ID, nil, checkin)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkin)
+}
+
+// HandleCheckinCheckout checks out (POST) or undoes the checkout (DELETE) of
+// a check-in: /api/checkins/{id}/checkout
+func HandleCheckinCheckout(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/checkins/")
+	parts := strings.Split(path, "/")
+
+	checkinID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetCheckinByID(checkinID)
+	if err != nil {
+		http.Error(w, "Check-in not found", http.StatusNotFound)
+		return
+	}
+
+	var after *models.Checkin
+	action := "checkout"
+	switch r.Method {
+	case http.MethodPost:
+		after, err = db.CheckoutCheckin(checkinID, db.CheckoutManual)
+	case http.MethodDelete:
+		action = "undo_checkout"
+		after, err = db.UndoCheckout(checkinID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, action, "checkin", checkinID, before, after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(after)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// code128Values reads the symbol values back out of encoded bars
+func code128Values(t *testing.T, bars []bool) []int {
+	t.Helper()
+	var widths strings.Builder
+	for i := 0; i < len(bars); {
+		start := i
+		for i < len(bars) && bars[i] == bars[start] {
+			i++
+		}
+		widths.WriteByte(byte('0' + i - start))
+	}
+
+	var values []int
+	rest := widths.String()
+	for len(rest) > 0 {
+		n := 6
+		if len(rest) == 7 {
+			n = 7
+		}
+		v := slices.Index(code128Patterns[:], rest[:n])
+		if v < 0 {
+			t.Fatalf("no symbol has widths %s", rest[:n])
+		}
+		values = append(values, v)
+		rest = rest[n:]
+	}
+	return values
+}
+
+// Check symbols are worked out by hand: the start value plus each value
+// times its position, mod 103
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		// 104 + 48 + 2*42 + 3*42 + 4*17 + 5*18 + 6*19 + 7*35 = 879, 879 mod 103 = 55
+		{"PJJ123C", []int{104, 48, 42, 42, 17, 18, 19, 35, 55, 106}},
+		// 104 + 55 + 2*73 + 3*75 + 4*73 + 5*80 + 6*69 + 7*68 + 8*73 + 9*65 = 3281, mod 103 = 88
+		{"Wikipedia", []int{104, 55, 73, 75, 73, 80, 69, 68, 73, 65, 88, 106}},
+		// Code set C: 105 + 12 + 2*34 = 185, mod 103 = 82
+		{"1234", []int{105, 12, 34, 82, 106}},
+		// An odd number of digits stays in code set B: 104 + 17 + 2*18 + 3*19 = 214, mod 103 = 8
+		{"123", []int{104, 17, 18, 19, 8, 106}},
+		{"", []int{104, 1, 106}},
+	}
+	for _, tt := range tests {
+		bars, err := encodeCode128(tt.input)
+		if err != nil {
+			t.Fatalf("encodeCode128(%q): %v", tt.input, err)
+		}
+		// Each symbol is 11 modules wide and the stop pattern 13
+		if want := 11*(len(tt.want)-1) + 13; len(bars) != want {
+			t.Errorf("encodeCode128(%q) is %d modules wide, want %d", tt.input, len(bars), want)
+		}
+		if got := code128Values(t, bars); !slices.Equal(got, tt.want) {
+			t.Errorf("encodeCode128(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeCode128Charset(t *testing.T) {
+	for _, input := range []string{"tab\there", "café", "\x7f"} {
+		if _, err := encodeCode128(input); err != errCode128Charset {
+			t.Errorf("encodeCode128(%q) error = %v, want errCode128Charset", input, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+var fieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var validFieldTypes = map[string]bool{
+	models.FieldText:    true,
+	models.FieldNumber:  true,
+	models.FieldDate:    true,
+	models.FieldBoolean: true,
+	models.FieldSelect:  true,
+}
+
+// memberImportColumns are the headers HandleMembersImport reads for the
+// built-in member details, which custom fields may not reuse
+var memberImportColumns = map[string]bool{
+	"membership_number": true, "membership number": true, "number": true,
+	"first_name": true, "first name": true, "firstname": true,
+	"last_name": true, "last name": true, "lastname": true, "surname": true,
+	"email": true, "phone": true, "active": true,
+}
+
+// HandleCustomFields handles /api/custom-fields and /api/custom-fields/{id}
+func HandleCustomFields(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/custom-fields"), "/")
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listCustomFields(w)
+		case http.MethodPost:
+			if !requirePermission(w, r, PermManageFields) {
+				return
+			}
+			createCustomField(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid field ID", http.StatusBadRequest)
+		return
+	}
+
+	field, err := db.GetCustomFieldByID(id)
+	if err != nil {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(field)
+	case http.MethodPut:
+		if !requirePermission(w, r, PermManageFields) {
+			return
+		}
+		updateCustomField(w, r, field)
+	case http.MethodDelete:
+		if !requirePermission(w, r, PermManageFields) {
+			return
+		}
+		if err := db.DeleteCustomField(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "delete", "custom_field", id, field, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listCustomFields(w http.ResponseWriter) {
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fields == nil {
+		fields = []models.CustomField{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+// validateCustomField checks a field definition. Labels are CSV column
+// headers, so they must not clash with each other or the built-in columns.
+func validateCustomField(req *models.CustomFieldRequest, id int64) error {
+	req.Key = strings.TrimSpace(req.Key)
+	req.Label = strings.TrimSpace(req.Label)
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+
+	if !fieldKeyPattern.MatchString(req.Key) {
+		return errors.New("key must be lowercase letters, digits and underscores, starting with a letter")
+	}
+	if req.Label == "" {
+		return errors.New("label is required")
+	}
+	if memberImportColumns[req.Key] || memberImportColumns[strings.ToLower(req.Label)] {
+		return errors.New("key and label must not match a built-in member column")
+	}
+	if !validFieldTypes[req.Type] {
+		return errors.New("type must be text, number, date, boolean or select")
+	}
+
+	var options []string
+	for _, o := range req.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	req.Options = options
+	if req.Type == models.FieldSelect && len(req.Options) == 0 {
+		return errors.New("select fields need options")
+	}
+	if req.Type != models.FieldSelect && len(req.Options) > 0 {
+		return errors.New("only select fields have options")
+	}
+
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.ID == id {
+			continue
+		}
+		if f.Key == req.Key || strings.EqualFold(f.Label, req.Label) ||
+			strings.EqualFold(f.Key, req.Label) || strings.EqualFold(f.Label, req.Key) {
+			return fmt.Errorf("key or label already used by field %q", f.Key)
+		}
+	}
+	return nil
+}
+
+func createCustomField(w http.ResponseWriter, r *http.Request) {
+	var req models.CustomFieldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateCustomField(&req, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	field, err := db.CreateCustomField(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "Field key already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "create", "custom_field", field.ID, nil, field)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(field)
+}
+
+func updateCustomField(w http.ResponseWriter, r *http.Request, before *models.CustomField) {
+	var req models.CustomFieldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// The key is how values and CSV files refer to the field, so it is fixed
+	if req.Key == "" {
+		req.Key = before.Key
+	}
+	if req.Key != before.Key {
+		http.Error(w, "key cannot be changed", http.StatusBadRequest)
+		return
+	}
+	if err := validateCustomField(&req, before.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	field, err := db.UpdateCustomField(before.ID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "update", "custom_field", field.ID, before, field)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+// fieldValue checks a value against its field and returns it in canonical
+// form: booleans as true/false, dates as YYYY-MM-DD, select options as defined
+func fieldValue(f models.CustomField, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	switch f.Type {
+	case models.FieldNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("%s must be a number", f.Label)
+		}
+	case models.FieldDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", f.Label)
+		}
+	case models.FieldBoolean:
+		switch strings.ToLower(value) {
+		case "true", "yes", "y", "1":
+			return "true", nil
+		case "false", "no", "n", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%s must be yes or no", f.Label)
+	case models.FieldSelect:
+		for _, o := range f.Options {
+			if strings.EqualFold(o, value) {
+				return o, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of: %s", f.Label, strings.Join(f.Options, ", "))
+	}
+	return value, nil
+}
+
+// checkFieldValues validates custom field values given by key and returns
+// them in canonical form. current holds the member's existing values; the
+// result merged over them must fill every required field.
+func checkFieldValues(fields []models.CustomField, values, current map[string]string) (map[string]string, error) {
+	byKey := make(map[string]models.CustomField, len(fields))
+	for _, f := range fields {
+		byKey[f.Key] = f
+	}
+
+	checked := make(map[string]string, len(values))
+	for key, value := range values {
+		f, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown custom field %q", key)
+		}
+		v, err := fieldValue(f, value)
+		if err != nil {
+			return nil, err
+		}
+		checked[key] = v
+	}
+
+	for _, f := range fields {
+		if !f.Required {
+			continue
+		}
+		v, given := checked[f.Key]
+		if !given {
+			v = current[f.Key]
+		}
+		if v == "" {
+			return nil, fmt.Errorf("%s is required", f.Label)
+		}
+	}
+	return checked, nil
+}
+
+// customFieldHeader and customFieldRow add the club's custom fields to a
+// member CSV, headed by label so the file can be imported again
+func customFieldHeader(fields []models.CustomField) []string {
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.Label
+	}
+	return header
+}
+
+func customFieldRow(fields []models.CustomField, values map[string]string) []string {
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = values[f.Key]
+	}
+	return row
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import "errors"
+
+// A Data Matrix (ECC 200) encoder for square symbols up to 48x48, which all
+// use a single Reed-Solomon block and hold up to 174 codewords: plenty for
+// a card code. Data is packed with ASCII encodation, two digits per codeword.
+
+type dataMatrixSize struct {
+	size      int // modules per side, including the finder patterns
+	region    int // modules per side of each data region
+	dataWords int
+	eccWords  int
+}
+
+var dataMatrixSizes = []dataMatrixSize{
+	{10, 8, 3, 5}, {12, 10, 5, 7}, {14, 12, 8, 10}, {16, 14, 12, 12},
+	{18, 16, 18, 14}, {20, 18, 22, 18}, {22, 20, 30, 20}, {24, 22, 36, 24},
+	{26, 24, 44, 28}, {32, 14, 62, 36}, {36, 16, 86, 42}, {40, 18, 114, 48},
+	{44, 20, 144, 56}, {48, 22, 174, 68},
+}
+
+var errDataMatrixTooLong = errors.New("too long for a Data Matrix code")
+
+// encodeDataMatrix returns the symbol's modules, row by row from the top
+func encodeDataMatrix(s string) ([][]bool, error) {
+	data := dataMatrixASCII([]byte(s))
+
+	var sym dataMatrixSize
+	for _, candidate := range dataMatrixSizes {
+		if len(data) <= candidate.dataWords {
+			sym = candidate
+			break
+		}
+	}
+	if sym.size == 0 {
+		return nil, errDataMatrixTooLong
+	}
+
+	// The first pad is 129, later ones are scrambled by their position
+	if len(data) < sym.dataWords {
+		data = append(data, 129)
+	}
+	for len(data) < sym.dataWords {
+		v := 129 + (149*(len(data)+1))%253 + 1
+		if v > 254 {
+			v -= 254
+		}
+		data = append(data, byte(v))
+	}
+	codewords := append(data, reedSolomon256(data, sym.eccWords)...)
+
+	regions := sym.size / (sym.region + 2)
+	n := regions * sym.region
+	placement := dataMatrixPlacement(n, n)
+
+	modules := make([][]bool, sym.size)
+	for y := range modules {
+		modules[y] = make([]bool, sym.size)
+		for x := range modules[y] {
+			ry, rx := y%(sym.region+2), x%(sym.region+2)
+			switch {
+			case rx == 0 || ry == sym.region+1:
+				// Solid L along the left and bottom of each region
+				modules[y][x] = true
+			case ry == 0:
+				// Alternating clock track along the top...
+				modules[y][x] = x%2 == 0
+			case rx == sym.region+1:
+				// ...and the right
+				modules[y][x] = y%2 == 1
+			default:
+				row := y/(sym.region+2)*sym.region + ry - 1
+				col := x/(sym.region+2)*sym.region + rx - 1
+				v := placement[row*n+col]
+				modules[y][x] = v == 1 || (v > 7 && codewords[(v>>3)-1]&(1<<(v&7)) != 0)
+			}
+		}
+	}
+	return modules, nil
+}
+
+func dataMatrixASCII(b []byte) []byte {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case isDigit(c) && i+1 < len(b) && isDigit(b[i+1]):
+			out = append(out, 130+(c-'0')*10+(b[i+1]-'0'))
+			i++
+		case c < 128:
+			out = append(out, c+1)
+		default:
+			// Upper shift, then the character less 128
+			out = append(out, 235, c-127)
+		}
+	}
+	return out
+}
+
+// reedSolomon256 returns the error correction codewords for data over
+// GF(256) with the Data Matrix field polynomial x^8+x^5+x^3+x^2+1
+func reedSolomon256(data []byte, n int) []byte {
+	var exp [255]byte
+	var log [256]int
+	v := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(v)
+		log[v] = i
+		v <<= 1
+		if v >= 256 {
+			v ^= 0x12d
+		}
+	}
+	mul := func(a, b byte) byte {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(log[a]+log[b])%255]
+	}
+
+	// Generator polynomial (x - a^1)(x - a^2)...(x - a^n), highest power first
+	gen := []byte{1}
+	for i := 1; i <= n; i++ {
+		next := make([]byte, len(gen)+1)
+		for k := range next {
+			if k < len(gen) {
+				next[k] = gen[k]
+			}
+			if k > 0 {
+				next[k] ^= mul(gen[k-1], exp[i])
+			}
+		}
+		gen = next
+	}
+
+	ecc := make([]byte, n)
+	for _, d := range data {
+		feedback := d ^ ecc[0]
+		copy(ecc, ecc[1:])
+		ecc[n-1] = 0
+		for j := 0; j < n; j++ {
+			ecc[j] ^= mul(feedback, gen[j+1])
+		}
+	}
+	return ecc
+}
+
+// dataMatrixPlacement lays codewords out over the nrow x ncol mapping
+// matrix, following the ECC 200 placement algorithm. Each entry is
+// codeword<<3 | bit, with codewords counted from 1 and bit 7 the most
+// significant; 1 marks the fixed dark modules of an unused corner.
+func dataMatrixPlacement(nrow, ncol int) []int {
+	array := make([]int, nrow*ncol)
+
+	module := func(r, c, p, b int) {
+		if r < 0 {
+			r += nrow
+			c += 4 - (nrow+4)%8
+		}
+		if c < 0 {
+			c += ncol
+			r += 4 - (ncol+4)%8
+		}
+		array[r*ncol+c] = p<<3 | b
+	}
+	utah := func(r, c, p int) {
+		module(r-2, c-2, p, 7)
+		module(r-2, c-1, p, 6)
+		module(r-1, c-2, p, 5)
+		module(r-1, c-1, p, 4)
+		module(r-1, c, p, 3)
+		module(r, c-2, p, 2)
+		module(r, c-1, p, 1)
+		module(r, c, p, 0)
+	}
+	corner := func(p int, positions [8][2]int) {
+		for i, pos := range positions {
+			module(pos[0], pos[1], p, 7-i)
+		}
+	}
+
+	p, r, c := 1, 4, 0
+	for {
+		switch {
+		case r == nrow && c == 0:
+			corner(p, [8][2]int{{nrow - 1, 0}, {nrow - 1, 1}, {nrow - 1, 2}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}, {2, ncol - 1}, {3, ncol - 1}})
+			p++
+		case r == nrow-2 && c == 0 && ncol%4 != 0:
+			corner(p, [8][2]int{{nrow - 3, 0}, {nrow - 2, 0}, {nrow - 1, 0}, {0, ncol - 4}, {0, ncol - 3}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}})
+			p++
+		case r == nrow-2 && c == 0 && ncol%8 == 4:
+			corner(p, [8][2]int{{nrow - 3, 0}, {nrow - 2, 0}, {nrow - 1, 0}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}, {2, ncol - 1}, {3, ncol - 1}})
+			p++
+		case r == nrow+4 && c == 2 && ncol%8 == 0:
+			corner(p, [8][2]int{{nrow - 1, 0}, {nrow - 1, ncol - 1}, {0, ncol - 3}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 3}, {1, ncol - 2}, {1, ncol - 1}})
+			p++
+		}
+
+		// Sweep up and to the right...
+		for {
+			if r < nrow && c >= 0 && array[r*ncol+c] == 0 {
+				utah(r, c, p)
+				p++
+			}
+			r -= 2
+			c += 2
+			if r < 0 || c >= ncol {
+				break
+			}
+		}
+		r++
+		c += 3
+
+		// ...then down and to the left
+		for {
+			if r >= 0 && c < ncol && array[r*ncol+c] == 0 {
+				utah(r, c, p)
+				p++
+			}
+			r += 2
+			c -= 2
+			if r >= nrow || c < 0 {
+				break
+			}
+		}
+		r += 3
+		c++
+
+		if r >= nrow && c >= ncol {
+			break
+		}
+	}
+
+	if array[nrow*ncol-1] == 0 {
+		array[nrow*ncol-1] = 1
+		array[nrow*ncol-ncol-2] = 1
+	}
+	return array
+}
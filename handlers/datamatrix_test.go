@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The reference symbols come from an independent ECC 200 encoder,
+// github.com/boombuler/barcode, which is a port of zxing's.
+func TestEncodeDataMatrix(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "ISO 16022 example",
+			input: "123456",
+			want: `
+				#.#.#.#.#.
+				##..#.##.#
+				##.....#..
+				##...###.#
+				##....#...
+				#.....####
+				###.##....
+				####.##..#
+				#..###.#..
+				##########`,
+		},
+		{
+			name:  "card code",
+			input: "TC1:ABC-12345:2:q8Zp3xKkT0aB9wLm",
+			want: `
+				#.#.#.#.#.#.#.#.#.#.#.
+				#.#.#.###...####.#.#.#
+				#....#.####....#.#..#.
+				##........#####......#
+				#..#.#.###.#..#...#.#.
+				##......##.##.###.####
+				##.####..#.###.#.#....
+				#..#.##.##..###.###.##
+				#..#......#..#.#.#..#.
+				##.#..##..##.#..#..#.#
+				#..#.##..###..##.##...
+				###.###.....##..#.#..#
+				##.#..######...######.
+				#...#.#.#.##.#.#.##.##
+				###......##.###.#.#.#.
+				#.#.#.##..##..##.#...#
+				#..#..#.#......#..##..
+				#...#...##..#.####.###
+				###..###.##....#..##..
+				#..#.#...#.#.##....###
+				#.#...###...#.####.##.
+				######################`,
+		},
+		{
+			name:  "four data regions",
+			input: strings.Repeat("x", 60),
+			want: `
+				#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.
+				#.#..####.#..######.#..####.####
+				####.#..####.#..#.####.#..####..
+				#..####.#..######.#..####.#..#.#
+				##.#..####.#..#.####.#..####.##.
+				#####.#..####.###..####.#..#####
+				##..####.#..###.##.#..#####...#.
+				###.#..####.#..######.#..#....##
+				#.####.#..####..##..####....###.
+				#.#..####.#..######.#..#...#...#
+				####.#..####.#..#.####..##..#...
+				#..####.#..######.#..#.##..##.##
+				##.#..####.#..#.####.###.#.###..
+				#####.#..####.###..#.##..#..#..#
+				##..####.#..###.##..####....###.
+				################################
+				#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.
+				###.#..####.#..#####...#..#...##
+				#.####.#..####..####.#.....#.##.
+				#.#..####.#..#.#####.###.#.#...#
+				####.#..#######.#.##...#....#...
+				#..####.#..#######.###...##.#.##
+				##.#..####......####..#..###....
+				#####.#..##.#####.....#.#.#.#.##
+				##..#####.#.....##...#.######.#.
+				###.#..#..###.###.##.#.#.#.##.##
+				#.####.....###..#.#..##.#######.
+				#.#..#.##.#.#####..#.#.#..#....#
+				#####.###.##.##.#.#..#.#..###...
+				#..#....#.#.#.###.#.#..##.##.###
+				#.##.#.###.#..#.####.#...###.#..
+				################################`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, err := encodeDataMatrix(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got strings.Builder
+			for _, row := range modules {
+				got.WriteByte('\n')
+				for _, dark := range row {
+					if dark {
+						got.WriteByte('#')
+					} else {
+						got.WriteByte('.')
+					}
+				}
+			}
+			want := strings.ReplaceAll(tt.want, "\t", "")
+			if got.String() != want {
+				t.Errorf("symbol mismatch\ngot:%s\nwant:%s", got.String(), want)
+			}
+		})
+	}
+}
+
+func TestEncodeDataMatrixTooLong(t *testing.T) {
+	if _, err := encodeDataMatrix(strings.Repeat("A", 175)); err != errDataMatrixTooLong {
+		t.Errorf("got %v, want errDataMatrixTooLong", err)
+	}
+	if _, err := encodeDataMatrix(strings.Repeat("A", 174)); err != nil {
+		t.Errorf("174 codewords should fit a 48x48 symbol: %v", err)
+	}
+}
+
+func TestDataMatrixASCII(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []byte
+	}{
+		{"123456", []byte{142, 164, 186}},
+		{"A", []byte{66}},
+		{"A1B", []byte{66, 50, 67}},
+		{"12345", []byte{142, 164, 54}},
+		{"\xe9", []byte{235, 106}},
+	}
+	for _, tt := range tests {
+		if got := dataMatrixASCII([]byte(tt.input)); !bytes.Equal(got, tt.want) {
+			t.Errorf("dataMatrixASCII(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// The vectors are from ISO/IEC 16022 annex R, as used by zxing's tests
+func TestReedSolomon256(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []byte
+	}{
+		{[]byte{142, 164, 186}, []byte{114, 25, 5, 88, 102}},
+		{[]byte{66, 129, 70}, []byte{138, 234, 82, 82, 95}},
+	}
+	for _, tt := range tests {
+		if got := reedSolomon256(tt.data, 5); !bytes.Equal(got, tt.want) {
+			t.Errorf("reedSolomon256(%v) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
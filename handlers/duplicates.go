@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// defaultDuplicateScore is the name similarity that flags a pair; the same
+// threshold RSVPs use to match a name to a member
+const defaultDuplicateScore = 80
+
+// findDuplicates reads ?active=false and ?min_score= and returns the candidates
+func findDuplicates(w http.ResponseWriter, r *http.Request) ([]models.DuplicateCandidate, bool) {
+	activeOnly := r.URL.Query().Get("active") != "false"
+	minScore := defaultDuplicateScore
+	if v := r.URL.Query().Get("min_score"); v != "" {
+		score, err := strconv.Atoi(v)
+		if err != nil || score < 1 || score > 100 {
+			http.Error(w, "min_score must be between 1 and 100", http.StatusBadRequest)
+			return nil, false
+		}
+		minScore = score
+	}
+
+	candidates, err := db.FindDuplicateMembers(activeOnly, minScore)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return candidates, true
+}
+
+// listDuplicates handles GET /api/members/duplicates
+func listDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	candidates, ok := findDuplicates(w, r)
+	if !ok {
+		return
+	}
+	if candidates == nil {
+		candidates = []models.DuplicateCandidate{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candidates)
+}
+
+// handleDuplicateReport exports the duplicate candidates as CSV:
+// /api/reports/duplicates
+func handleDuplicateReport(w http.ResponseWriter, r *http.Request) {
+	candidates, ok := findDuplicates(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"duplicate_members.csv\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	writer.Write([]string{
+		"Membership Number", "First Name", "Last Name", "Email", "Phone",
+		"Match Number", "Match First Name", "Match Last Name", "Match Email", "Match Phone",
+		"Name Score", "Reasons",
+	})
+
+	// Data
+	for _, c := range candidates {
+		writer.Write([]string{
+			c.Member.MembershipNumber, c.Member.FirstName, c.Member.LastName, c.Member.Email, c.Member.Phone,
+			c.Match.MembershipNumber, c.Match.FirstName, c.Match.LastName, c.Match.Email, c.Match.Phone,
+			strconv.Itoa(c.NameScore), strings.Join(c.Reasons, ", "),
+		})
+	}
+}
+
+// mergeMember handles POST /api/members/{id}/merge, merging the member named
+// by duplicate_id into member id
+func mergeMember(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, PermDeleteMembers) {
+		return
+	}
+
+	var req models.MergeMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.DuplicateID == 0 {
+		http.Error(w, "duplicate_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.DuplicateID == id {
+		http.Error(w, "A member can't be merged into itself", http.StatusBadRequest)
+		return
+	}
+
+	survivor, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	duplicate, err := db.GetMemberByID(req.DuplicateID)
+	if err != nil {
+		http.Error(w, "Duplicate member not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := db.MergeMembers(id, req.DuplicateID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	duplicateAfter, _ := db.GetMemberByID(req.DuplicateID)
+	recordAudit(r, "merge", "member", id, survivor, result)
+	recordAudit(r, "merged_into", "member", req.DuplicateID, duplicate, duplicateAfter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
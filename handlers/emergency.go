@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// HandleMemberEmergencyInfo handles PUT /api/members/{id}/emergency. The
+// details are write-only here; they are read back through a hike's roster.
+func HandleMemberEmergencyInfo(w http.ResponseWriter, r *http.Request, memberID int64) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	if !db.EncryptionEnabled() {
+		writeJSONError(w, "Emergency details are disabled: TRAILCALL_ENCRYPTION_KEY is not set", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req models.EmergencyInfo
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.ContactName = strings.TrimSpace(req.ContactName)
+	req.ContactPhone = strings.TrimSpace(req.ContactPhone)
+	req.MedicalNotes = strings.TrimSpace(req.MedicalNotes)
+
+	before, err := db.GetMemberByID(memberID)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err := db.SetEmergencyInfo(memberID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only record that the details changed, never the details themselves
+	after, _ := db.GetMemberByID(memberID)
+	recordAudit(r, "update_emergency_info", "member", memberID,
+		map[string]bool{"has_emergency_info": before.HasEmergencyInfo},
+		map[string]bool{"has_emergency_info": after != nil && after.HasEmergencyInfo})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(after)
+}
+
+// rosterRefusal returns why the current user may not read a hike's emergency
+// roster, or "" if they may. The roster is available while the hike is open,
+// or while it has an unresolved alert, to the hike's assigned leaders and to
+// admins.
+func rosterRefusal(r *http.Request, hike *models.Hike) (string, error) {
+	user := CurrentUser(r)
+	if user.Role != models.RoleAdmin {
+		leader, err := db.IsHikeLeader(hike.ID, user.ID)
+		if err != nil {
+			return "", err
+		}
+		if !leader {
+			return "only the hike's assigned leaders can view its emergency roster", nil
+		}
+	}
+	if hike.Status != "closed" {
+		return "", nil
+	}
+	alerts, err := db.GetAlerts(hike.ID, "open")
+	if err != nil {
+		return "", err
+	}
+	if len(alerts) == 0 {
+		return "the emergency roster is only available until the hike is closed, unless it has an open alert", nil
+	}
+	return "", nil
+}
+
+// HandleEmergencyRoster handles GET /api/hikes/{id}/emergency-roster, with
+// format=html for a printable page. Every view, and every refused attempt, is
+// recorded in the audit log against the hike.
+func HandleEmergencyRoster(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermViewEmergencyInfo) {
+		return
+	}
+
+	hike, err := db.GetHikeByID(hikeID)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+	refusal, err := rosterRefusal(r, hike)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if refusal != "" {
+		recordAudit(r, "view_emergency_roster_denied", "hike", hikeID, nil, map[string]string{"reason": refusal})
+		writeJSONError(w, "Forbidden: "+refusal, http.StatusForbidden)
+		return
+	}
+
+	roster, err := db.GetEmergencyRoster(hikeID)
+	if err != nil {
+		if errors.Is(err, db.ErrNoEncryptionKey) {
+			writeJSONError(w, "Emergency details are disabled: TRAILCALL_ENCRYPTION_KEY is not set", http.StatusServiceUnavailable)
+			return
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Hike not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Record whose details were shown, never the details themselves
+	memberIDs := []int64{}
+	for _, e := range roster.Entries {
+		if e.MemberID != nil {
+			memberIDs = append(memberIDs, *e.MemberID)
+		}
+	}
+	recordAudit(r, "view_emergency_roster", "hike", hikeID, nil, map[string]interface{}{"member_ids": memberIDs})
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rosterTemplate.Execute(w, roster)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roster)
+}
+
+var rosterTemplate = template.Must(template.New("roster").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Emergency Roster - {{.HikeName}}</title>
+<style>
+	body { font-family: sans-serif; font-size: 11pt; margin: 1.5cm; }
+	h1 { font-size: 16pt; margin-bottom: 0; }
+	table { width: 100%; border-collapse: collapse; margin-top: 1em; }
+	th, td { border: 1px solid #999; padding: 4px 6px; text-align: left; vertical-align: top; }
+	th { background: #eee; }
+	.confidential { color: #b00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Emergency Roster: {{.HikeName}}</h1>
+<p>{{.HikeDate}}{{if .Location}} &middot; {{.Location}}{{end}} &middot; printed {{.GeneratedAt.Format "2006-01-02 15:04"}} UTC</p>
+<p class="confidential">Confidential: contains medical information. Destroy after the hike.</p>
+<table>
+<tr><th>Name</th><th>Member #</th><th>Phone</th><th>Role</th><th>Emergency Contact</th><th>Medical Notes</th></tr>
+{{range .Entries}}<tr>
+<td>{{.Name}}</td>
+<td>{{if .IsGuest}}Guest{{else}}{{.MembershipNumber}}{{end}}</td>
+<td>{{.Phone}}</td>
+<td>{{if .IsLeader}}Leader {{end}}{{if .IsSweeper}}Sweeper{{end}}</td>
+<td>{{with .EmergencyInfo}}{{.ContactName}} {{.ContactPhone}}{{end}}</td>
+<td>{{with .EmergencyInfo}}{{.MedicalNotes}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
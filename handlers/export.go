@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// handleMembersExport exports members: /api/reports/members. Options:
+//
+//	format     csv (default), json or vcf
+//	status     all (default), active or inactive
+//	joined_from, joined_to  YYYY-MM-DD, inclusive, by the date the member was added
+//
+// The CSV has the columns HandleMembersImport reads, so it can be edited and
+// imported again.
+func handleMembersExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var f models.MemberFilter
+	switch q.Get("status") {
+	case "", "all":
+	case "active":
+		active := true
+		f.Active = &active
+	case "inactive":
+		active := false
+		f.Active = &active
+	default:
+		http.Error(w, "status must be all, active or inactive", http.StatusBadRequest)
+		return
+	}
+	f.JoinedFrom = q.Get("joined_from")
+	if _, err := time.Parse("2006-01-02", f.JoinedFrom); f.JoinedFrom != "" && err != nil {
+		http.Error(w, "joined_from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	f.JoinedTo = q.Get("joined_to")
+	if _, err := time.Parse("2006-01-02", f.JoinedTo); f.JoinedTo != "" && err != nil {
+		http.Error(w, "joined_to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "json", "vcf":
+	default:
+		http.Error(w, "format must be csv, json or vcf", http.StatusBadRequest)
+		return
+	}
+
+	members, err := db.GetMembers(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "json":
+		if members == nil {
+			members = []models.Member{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
+	case "vcf":
+		w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"members.vcf\"")
+		org := clubName()
+		for _, m := range members {
+			writeVCard(w, m, org)
+		}
+	default:
+		fields, err := db.GetCustomFields()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		exportMembersCSV(w, members, fields)
+	}
+}
+
+func exportMembersCSV(w http.ResponseWriter, members []models.Member, fields []models.CustomField) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"members.csv\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	header := []string{"membership_number", "first_name", "last_name", "email", "phone", "active"}
+	writer.Write(append(header, customFieldHeader(fields)...))
+
+	// Data
+	for _, m := range members {
+		active := "no"
+		if m.Active {
+			active = "yes"
+		}
+		row := []string{m.MembershipNumber, m.FirstName, m.LastName, m.Email, m.Phone, active}
+		writer.Write(append(row, customFieldRow(fields, m.CustomFields)...))
+	}
+}
+
+// writeVCard writes one member as a vCard 3.0 contact
+func writeVCard(w io.Writer, m models.Member, org string) {
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:" + vcardEscape(m.LastName) + ";" + vcardEscape(m.FirstName) + ";;;",
+		"FN:" + vcardEscape(m.FirstName+" "+m.LastName),
+		"ORG:" + vcardEscape(org),
+	}
+	if m.Email != "" {
+		lines = append(lines, "EMAIL;TYPE=INTERNET:"+vcardEscape(m.Email))
+	}
+	if m.Phone != "" {
+		lines = append(lines, "TEL;TYPE=CELL:"+vcardEscape(m.Phone))
+	}
+	lines = append(lines,
+		"NOTE:"+vcardEscape("Membership number "+m.MembershipNumber),
+		"END:VCARD",
+	)
+	for _, line := range lines {
+		fmt.Fprint(w, vcardFold(line)+"\r\n")
+	}
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func vcardEscape(s string) string {
+	return vcardEscaper.Replace(s)
+}
+
+// vcardFold splits a line longer than 75 bytes into continuation lines,
+// without breaking a UTF-8 character
+func vcardFold(line string) string {
+	const limit = 75
+	var b strings.Builder
+	width := 0
+	for _, c := range line {
+		n := len(string(c))
+		if width+n > limit {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(c)
+		width += n
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// HandleHikeLeaders handles GET and PUT /api/hikes/{id}/leaders. PUT replaces
+// the hike's leaders with the users named in usernames.
+func HandleHikeLeaders(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	before, err := db.GetHikeLeaders(hikeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if before == nil {
+		before = []models.User{}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := db.GetHikeByID(hikeID); err != nil {
+			http.Error(w, "Hike not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(before)
+	case http.MethodPut:
+		if !requirePermission(w, r, PermAssignLeaders) {
+			return
+		}
+		setHikeLeaders(w, r, hikeID, before)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func setHikeLeaders(w http.ResponseWriter, r *http.Request, hikeID int64, before []models.User) {
+	if _, err := db.GetHikeByID(hikeID); err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.HikeLeadersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var userIDs []int64
+	for _, username := range req.Usernames {
+		username = strings.ToLower(strings.TrimSpace(username))
+		if username == "" {
+			continue
+		}
+		user, err := db.GetUserByUsername(username)
+		if err != nil || !user.Active {
+			http.Error(w, fmt.Sprintf("Unknown or inactive user %q", username), http.StatusBadRequest)
+			return
+		}
+		userIDs = append(userIDs, user.ID)
+	}
+
+	if err := db.SetHikeLeaders(hikeID, userIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	leaders, err := db.GetHikeLeaders(hikeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if leaders == nil {
+		leaders = []models.User{}
+	}
+	recordAudit(r, "update_leaders", "hike", hikeID, before, leaders)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leaders)
+}
@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,6 +18,9 @@ func HandleHikes(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		listHikes(w, r)
 	case http.MethodPost:
+		if !requirePermission(w, r, PermManageHikes) {
+			return
+		}
 		createHike(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -42,22 +48,52 @@ func HandleHike(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for sub-routes: /api/hikes/{id}/close, /api/hikes/{id}/checkins, /api/hikes/{id}/rsvps, /api/hikes/{id}/activities
+	// Check for sub-routes: /api/hikes/{id}/close, /api/hikes/{id}/reopen, /api/hikes/{id}/checkins, /api/hikes/{id}/rsvps, /api/hikes/{id}/activities, /api/hikes/{id}/incidents, /api/hikes/{id}/leaders
 	if len(parts) >= 2 {
 		switch parts[1] {
 		case "close":
+			if !requirePermission(w, r, PermManageHikes) {
+				return
+			}
 			closeHike(w, r, id)
 			return
+		case "reopen":
+			if !requirePermission(w, r, PermReopenHikes) {
+				return
+			}
+			reopenHike(w, r, id)
+			return
 		case "checkins":
 			getHikeCheckins(w, r, id)
 			return
+		case "headcount":
+			getHeadcount(w, r, id)
+			return
+		case "alerts":
+			HandleHikeAlerts(w, r, id)
+			return
+		case "emergency-sheet":
+			HandleEmergencySheet(w, r, id)
+			return
+		case "emergency-roster":
+			HandleEmergencyRoster(w, r, id)
+			return
+		case "leaders":
+			HandleHikeLeaders(w, r, id)
+			return
 		case "rsvps":
 			if len(parts) == 3 {
 				switch parts[2] {
 				case "close":
+					if !requirePermission(w, r, PermManageHikes) {
+						return
+					}
 					HandleCloseRSVPs(w, r, id)
 					return
 				case "open":
+					if !requirePermission(w, r, PermReopenRSVPs) {
+						return
+					}
 					HandleOpenRSVPs(w, r, id)
 					return
 				}
@@ -67,6 +103,9 @@ func HandleHike(w http.ResponseWriter, r *http.Request) {
 		case "activities":
 			HandleActivities(w, r, id)
 			return
+		case "incidents":
+			HandleIncidents(w, r, id, parts[2:])
+			return
 		}
 	}
 
@@ -74,6 +113,9 @@ func HandleHike(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		getHike(w, r, id)
 	case http.MethodPut:
+		if !requirePermission(w, r, PermManageHikes) {
+			return
+		}
 		updateHike(w, r, id)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -147,6 +189,8 @@ func createHike(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAudit(r, "create", "hike", hike.ID, nil, hike)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(hike)
@@ -159,12 +203,20 @@ func updateHike(w http.ResponseWriter, r *http.Request, id int64) {
 		return
 	}
 
+	before, err := db.GetHikeByID(id)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
 	hike, err := db.UpdateHike(id, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, "update", "hike", id, before, hike)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(hike)
 }
@@ -175,16 +227,125 @@ func closeHike(w http.ResponseWriter, r *http.Request, id int64) {
 		return
 	}
 
+	// The body is optional; older clients send none
+	var req models.CloseHikeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetHikeByID(id)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
+	headcount, err := db.GetHeadcount(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if headcount.Outstanding > 0 && !req.Override {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     fmt.Sprintf("%d of %d people have not been checked out", headcount.Outstanding, headcount.Started),
+			"headcount": headcount,
+		})
+		return
+	}
+
 	hike, err := db.CloseHike(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	var after interface{} = hike
+	if headcount.Outstanding > 0 {
+		after = map[string]interface{}{
+			"hike":        hike,
+			"override":    true,
+			"outstanding": headcount.Outstanding,
+			"reason":      req.Reason,
+		}
+	}
+	recordAudit(r, "close", "hike", id, before, after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hike)
+}
+
+func reopenHike(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ReopenHikeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	before, err := db.GetHikeByID(id)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
+	hike, err := db.ReopenHike(id, CurrentUser(r).ID, req.Reason)
+	if err != nil {
+		if errors.Is(err, db.ErrHikeNotClosed) {
+			writeJSONError(w, "Hike is not closed", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "reopen", "hike", id, before, map[string]interface{}{"hike": hike, "reason": req.Reason})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(hike)
 }
 
+// writeHikeClosed sends a 409 and returns true if err reports a closed hike
+func writeHikeClosed(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, db.ErrHikeClosed) {
+		return false
+	}
+	writeJSONError(w, "Hike is closed. An admin must reopen it before attendance can change", http.StatusConflict)
+	return true
+}
+
+// getHeadcount reports who has started the hike and who is still out
+func getHeadcount(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := db.GetHikeByID(id); err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
+	headcount, err := db.GetHeadcount(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(headcount)
+}
+
 func getHikeCheckins(w http.ResponseWriter, r *http.Request, id int64) {
 	checkins, err := db.GetCheckinsForHike(id)
 	if err != nil {
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// HandleHouseholds handles /api/households and /api/households/{id}
+func HandleHouseholds(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/households"), "/")
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listHouseholds(w)
+		case http.MethodPost:
+			if !requirePermission(w, r, PermEditMembers) {
+				return
+			}
+			createHousehold(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid household ID", http.StatusBadRequest)
+		return
+	}
+
+	household, err := db.GetHouseholdByID(id)
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(household)
+	case http.MethodPut:
+		if !requirePermission(w, r, PermEditMembers) {
+			return
+		}
+		updateHousehold(w, r, household)
+	case http.MethodDelete:
+		if !requirePermission(w, r, PermEditMembers) {
+			return
+		}
+		if err := db.DeleteHousehold(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "delete", "household", id, household, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listHouseholds(w http.ResponseWriter) {
+	households, err := db.GetAllHouseholds()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if households == nil {
+		households = []models.Household{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(households)
+}
+
+// validateHousehold checks the name and makes sure the primary contact is a member
+func validateHousehold(req *models.HouseholdRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if req.PrimaryMemberID != nil {
+		found := false
+		for _, id := range req.MemberIDs {
+			if id == *req.PrimaryMemberID {
+				found = true
+			}
+		}
+		if !found {
+			req.MemberIDs = append(req.MemberIDs, *req.PrimaryMemberID)
+		}
+	}
+	return nil
+}
+
+func createHousehold(w http.ResponseWriter, r *http.Request) {
+	var req models.HouseholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateHousehold(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	household, err := db.CreateHousehold(req)
+	if err != nil {
+		if errors.Is(err, db.ErrHouseholdMember) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "create", "household", household.ID, nil, household)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(household)
+}
+
+func updateHousehold(w http.ResponseWriter, r *http.Request, before *models.Household) {
+	var req models.HouseholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateHousehold(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	household, err := db.UpdateHousehold(before.ID, req)
+	if err != nil {
+		if errors.Is(err, db.ErrHouseholdMember) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "update", "household", household.ID, before, household)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(household)
+}
+
+// handleHouseholdCheckin handles POST /api/checkins/household: scanning one
+// member's card checks in the selected members of their household. Each
+// member gets a result with status created or duplicate.
+func handleHouseholdCheckin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.HouseholdCheckinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.HikeID == 0 || req.MembershipNumber == "" || len(req.MemberIDs) == 0 {
+		http.Error(w, "hike_id, membership_number and member_ids are required", http.StatusBadRequest)
+		return
+	}
+
+	membershipNumber, err := resolveCheckinCode(r, req.MembershipNumber, req.Manual)
+	if err != nil {
+		if writeCardError(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "no rows") {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cardholder, err := db.GetMemberByMembershipNumber(membershipNumber)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if cardholder.HouseholdID == nil {
+		http.Error(w, "Member is not part of a household", http.StatusBadRequest)
+		return
+	}
+
+	household, err := db.GetHouseholdByID(*cardholder.HouseholdID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	inHousehold := make(map[int64]*models.Member)
+	for i := range household.Members {
+		inHousehold[household.Members[i].ID] = &household.Members[i]
+	}
+
+	// Check everyone before checking anyone in
+	var members []*models.Member
+	for _, id := range req.MemberIDs {
+		m, ok := inHousehold[id]
+		if !ok {
+			http.Error(w, "Member "+strconv.FormatInt(id, 10)+" is not in this household", http.StatusBadRequest)
+			return
+		}
+		members = append(members, m)
+	}
+
+	// Members already checked in are reported as duplicates and not audited again
+	results := make([]models.BulkCheckinResult, 0, len(members))
+	status := http.StatusOK
+	for _, m := range members {
+		checkin, created, err := db.CreateOfflineCheckin(req.HikeID, m.MembershipNumber, nil, "")
+		if err != nil {
+			if writeHikeClosed(w, err) {
+				return
+			}
+			if strings.Contains(err.Error(), "no rows") {
+				http.Error(w, "Member not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result := models.BulkCheckinResult{
+			MembershipNumber: m.MembershipNumber,
+			Status:           models.BulkStatusDuplicate,
+			Checkin:          checkin,
+		}
+		if created {
+			result.Status = models.BulkStatusCreated
+			status = http.StatusCreated
+			recordAudit(r, checkinAction(req.Manual), "checkin", checkin.ID, nil, checkin)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trailcall/models"
+)
+
+// importColumns holds the index of each column in a member CSV, -1 when absent
+type importColumns struct {
+	number, first, last, email, phone, active int
+	fields                                    map[string]int // custom field key to column
+}
+
+// firstColumn returns the index of the first of names present in the header
+func firstColumn(colMap map[string]int, names ...string) int {
+	for _, name := range names {
+		if i, ok := colMap[name]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func parseImportHeader(header []string, fields []models.CustomField) (importColumns, error) {
+	colMap := make(map[string]int)
+	for i, col := range header {
+		colMap[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+
+	cols := importColumns{
+		number: firstColumn(colMap, "membership_number", "membership number", "number"),
+		first:  firstColumn(colMap, "first_name", "first name", "firstname"),
+		last:   firstColumn(colMap, "last_name", "last name", "lastname", "surname"),
+		email:  firstColumn(colMap, "email"),
+		phone:  firstColumn(colMap, "phone"),
+		active: firstColumn(colMap, "active"),
+		fields: make(map[string]int),
+	}
+	if cols.number < 0 || cols.first < 0 || cols.last < 0 {
+		return cols, fmt.Errorf("CSV must have columns: membership_number, first_name, last_name")
+	}
+
+	// Custom field columns, headed by the field's key or label
+	for _, f := range fields {
+		if i := firstColumn(colMap, f.Key, strings.ToLower(f.Label)); i >= 0 {
+			cols.fields[f.Key] = i
+		}
+	}
+	return cols, nil
+}
+
+// cell returns a trimmed value, or "" for a column that is absent or missing
+// from a short row
+func cell(record []string, col int) string {
+	if col < 0 || col >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[col])
+}
+
+func parseActive(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "y", "1", "active":
+		return true, nil
+	case "false", "no", "n", "0", "inactive":
+		return false, nil
+	}
+	return false, fmt.Errorf("active must be yes or no")
+}
+
+// importPlan works out, row by row, what an import will change
+type importPlan struct {
+	mode     string
+	cols     importColumns
+	fields   []models.CustomField
+	existing map[string]*models.Member // by membership number
+	seen     map[string]int            // membership number to the line it was first on
+
+	create []models.ImportedMember
+	update []models.ImportedMember
+	before []*models.Member // the members in update, as they were
+	// Sync mode: active members missing from the file
+	deactivate []*models.Member
+	result     models.ImportResult
+}
+
+func newImportPlan(mode string, cols importColumns, fields []models.CustomField, members []models.Member) *importPlan {
+	p := &importPlan{
+		mode:     mode,
+		cols:     cols,
+		fields:   fields,
+		existing: make(map[string]*models.Member, len(members)),
+		seen:     make(map[string]int),
+		result:   models.ImportResult{Mode: mode, Rows: []models.ImportRowResult{}},
+	}
+	for i := range members {
+		p.existing[members[i].MembershipNumber] = &members[i]
+	}
+	return p
+}
+
+func (p *importPlan) addError(row models.ImportRowResult, reason string) {
+	row.Action = models.ImportError
+	row.Reason = reason
+	p.result.Errors++
+	p.result.ErrorMessages = append(p.result.ErrorMessages, fmt.Sprintf("line %d: %s", row.Line, reason))
+	p.result.Rows = append(p.result.Rows, row)
+}
+
+// addRow plans one CSV record
+func (p *importPlan) addRow(line int, record []string) {
+	number := cell(record, p.cols.number)
+	firstName := cell(record, p.cols.first)
+	lastName := cell(record, p.cols.last)
+	row := models.ImportRowResult{Line: line, MembershipNumber: number, Name: strings.TrimSpace(firstName + " " + lastName)}
+
+	blank := true
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			blank = false
+		}
+	}
+	if blank {
+		return
+	}
+
+	// The number is recorded even if the row is rejected below, so a sync
+	// doesn't deactivate a member who is in the file
+	if number != "" {
+		if first, ok := p.seen[number]; ok {
+			p.addError(row, "membership number is also on line "+strconv.Itoa(first))
+			return
+		}
+		p.seen[number] = line
+	}
+
+	var missing []string
+	if number == "" {
+		missing = append(missing, "membership_number")
+	}
+	if firstName == "" {
+		missing = append(missing, "first_name")
+	}
+	if lastName == "" {
+		missing = append(missing, "last_name")
+	}
+	if len(missing) > 0 {
+		p.addError(row, "missing "+strings.Join(missing, ", "))
+		return
+	}
+
+	values := make(map[string]string)
+	for key, col := range p.cols.fields {
+		values[key] = cell(record, col)
+	}
+
+	existing := p.existing[number]
+	if existing == nil {
+		p.planCreate(row, record, values)
+		return
+	}
+	if p.mode == models.ImportInsert {
+		row.Action = models.ImportSkip
+		row.Reason = "already exists"
+		p.result.Skipped++
+		p.result.Rows = append(p.result.Rows, row)
+		return
+	}
+	p.planUpdate(row, record, values, existing)
+}
+
+func (p *importPlan) planCreate(row models.ImportRowResult, record []string, values map[string]string) {
+	m := models.ImportedMember{
+		MembershipNumber: row.MembershipNumber,
+		FirstName:        cell(record, p.cols.first),
+		LastName:         cell(record, p.cols.last),
+		Email:            cell(record, p.cols.email),
+		Phone:            cell(record, p.cols.phone),
+		Active:           true,
+	}
+	if v := cell(record, p.cols.active); v != "" {
+		active, err := parseActive(v)
+		if err != nil {
+			p.addError(row, err.Error())
+			return
+		}
+		m.Active = active
+	}
+
+	checked, err := checkFieldValues(p.fields, values, nil)
+	if err != nil {
+		p.addError(row, err.Error())
+		return
+	}
+	m.CustomFields = make(map[string]string)
+	for key, v := range checked {
+		if v != "" {
+			m.CustomFields[key] = v
+		}
+	}
+
+	row.Action = models.ImportCreate
+	row.Changes = memberChanges(&models.ImportedMember{Active: m.Active}, &m, nil)
+	p.create = append(p.create, m)
+	p.result.Created++
+	p.result.Rows = append(p.result.Rows, row)
+}
+
+// planUpdate changes only the columns present in the file; an empty cell in
+// a present column clears the value
+func (p *importPlan) planUpdate(row models.ImportRowResult, record []string, values map[string]string, existing *models.Member) {
+	row.Name = existing.FirstName + " " + existing.LastName
+	m := models.ImportedMember{
+		ID:               existing.ID,
+		MembershipNumber: existing.MembershipNumber,
+		FirstName:        cell(record, p.cols.first),
+		LastName:         cell(record, p.cols.last),
+		Email:            existing.Email,
+		Phone:            existing.Phone,
+		Active:           existing.Active,
+	}
+	if p.cols.email >= 0 {
+		m.Email = cell(record, p.cols.email)
+	}
+	if p.cols.phone >= 0 {
+		m.Phone = cell(record, p.cols.phone)
+	}
+	if v := cell(record, p.cols.active); v != "" {
+		active, err := parseActive(v)
+		if err != nil {
+			p.addError(row, err.Error())
+			return
+		}
+		m.Active = active
+	} else if p.mode == models.ImportSync {
+		// Being in the file is what makes a member current
+		m.Active = true
+	}
+
+	checked, err := checkFieldValues(p.fields, values, existing.CustomFields)
+	if err != nil {
+		p.addError(row, err.Error())
+		return
+	}
+	m.CustomFields = make(map[string]string)
+	for key, v := range checked {
+		if v != existing.CustomFields[key] {
+			m.CustomFields[key] = v
+		}
+	}
+
+	old := models.ImportedMember{
+		FirstName: existing.FirstName,
+		LastName:  existing.LastName,
+		Email:     existing.Email,
+		Phone:     existing.Phone,
+		Active:    existing.Active,
+	}
+	row.Changes = memberChanges(&old, &m, existing.CustomFields)
+	if len(row.Changes) == 0 {
+		row.Action = models.ImportUnchanged
+		p.result.Unchanged++
+		p.result.Rows = append(p.result.Rows, row)
+		return
+	}
+
+	row.Action = models.ImportUpdate
+	p.update = append(p.update, m)
+	p.before = append(p.before, existing)
+	p.result.Updated++
+	p.result.Rows = append(p.result.Rows, row)
+}
+
+// planDeactivations adds, for sync mode, every active member not in the file
+func (p *importPlan) planDeactivations() {
+	numbers := make([]string, 0, len(p.existing))
+	for number := range p.existing {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+
+	for _, number := range numbers {
+		m := p.existing[number]
+		if _, inFile := p.seen[number]; inFile || !m.Active {
+			continue
+		}
+		p.deactivate = append(p.deactivate, m)
+		p.result.Deactivated++
+		p.result.Rows = append(p.result.Rows, models.ImportRowResult{
+			MembershipNumber: number,
+			Name:             m.FirstName + " " + m.LastName,
+			Action:           models.ImportDeactivate,
+			Changes:          map[string]models.ImportFieldChange{"active": {From: "true", To: "false"}},
+		})
+	}
+}
+
+// memberChanges lists the differences between old and m, keyed by import
+// column name. oldFields are the existing custom field values; m.CustomFields
+// holds only the changed ones.
+func memberChanges(old, m *models.ImportedMember, oldFields map[string]string) map[string]models.ImportFieldChange {
+	changes := make(map[string]models.ImportFieldChange)
+	compare := func(name, from, to string) {
+		if from != to {
+			changes[name] = models.ImportFieldChange{From: from, To: to}
+		}
+	}
+	compare("first_name", old.FirstName, m.FirstName)
+	compare("last_name", old.LastName, m.LastName)
+	compare("email", old.Email, m.Email)
+	compare("phone", old.Phone, m.Phone)
+	compare("active", strconv.FormatBool(old.Active), strconv.FormatBool(m.Active))
+	for key, v := range m.CustomFields {
+		compare(key, oldFields[key], v)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+var validSeverities = map[string]bool{
+	models.SeverityNearMiss: true,
+	models.SeverityMinor:    true,
+	models.SeverityModerate: true,
+	models.SeveritySerious:  true,
+}
+
+// HandleIncidents handles /api/hikes/{id}/incidents and
+// /api/hikes/{id}/incidents/{incidentID}. parts is the path after "incidents".
+func HandleIncidents(w http.ResponseWriter, r *http.Request, hikeID int64, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listIncidents(w, hikeID)
+		case http.MethodPost:
+			if !requirePermission(w, r, PermCheckin) {
+				return
+			}
+			createIncident(w, r, hikeID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid incident ID", http.StatusBadRequest)
+		return
+	}
+
+	incident, err := db.GetIncidentByID(id)
+	if err != nil || incident.HikeID != hikeID {
+		http.Error(w, "Incident not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incident)
+	case http.MethodPut:
+		if !requirePermission(w, r, PermCheckin) {
+			return
+		}
+		updateIncident(w, r, incident)
+	case http.MethodDelete:
+		if !requirePermission(w, r, PermManageHikes) {
+			return
+		}
+		if err := db.DeleteIncident(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "delete", "incident", id, incident, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateIncident tidies up an incident request and checks the required fields
+func validateIncident(req *models.IncidentRequest) error {
+	req.Severity = strings.TrimSpace(req.Severity)
+	req.Description = strings.TrimSpace(req.Description)
+	req.ActionsTaken = strings.TrimSpace(req.ActionsTaken)
+	if !validSeverities[req.Severity] {
+		return errors.New("severity must be near_miss, minor, moderate or serious")
+	}
+	if req.Description == "" {
+		return errors.New("description is required")
+	}
+	if req.OccurredAt != nil && req.OccurredAt.After(time.Now().Add(clockSkew)) {
+		return errors.New("occurred_at is in the future")
+	}
+	return nil
+}
+
+func listIncidents(w http.ResponseWriter, hikeID int64) {
+	incidents, err := db.GetIncidentsForHike(hikeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if incidents == nil {
+		incidents = []models.Incident{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(incidents)
+}
+
+func createIncident(w http.ResponseWriter, r *http.Request, hikeID int64) {
+	var req models.IncidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateIncident(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetHikeByID(hikeID); err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
+	incident, err := db.CreateIncident(hikeID, req, CurrentUser(r).ID, "")
+	if err != nil {
+		if errors.Is(err, db.ErrIncidentPerson) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "create", "incident", incident.ID, nil, incident)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(incident)
+}
+
+func updateIncident(w http.ResponseWriter, r *http.Request, before *models.Incident) {
+	var req models.IncidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateIncident(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	incident, err := db.UpdateIncident(before.ID, req)
+	if err != nil {
+		if errors.Is(err, db.ErrIncidentPerson) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "update", "incident", incident.ID, before, incident)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(incident)
+}
+
+// bulkIncidentItem stores one incident queued on a device while offline.
+// It runs after the batch's check-ins so people can be named by membership number.
+func bulkIncidentItem(r *http.Request, req models.IncidentRequest, deviceID string, hikes map[int64]*models.Hike) models.BulkIncidentResult {
+	result := models.BulkIncidentResult{ClientID: req.ClientID}
+	fail := func(status, message string) models.BulkIncidentResult {
+		result.Status = status
+		result.Error = message
+		return result
+	}
+
+	if req.ClientID != "" {
+		id, found, err := db.GetIncidentIDForClientID(req.ClientID)
+		if err != nil {
+			return fail(models.BulkStatusError, err.Error())
+		}
+		if found {
+			result.Status = models.BulkStatusDuplicate
+			result.Incident, _ = db.GetIncidentByID(id)
+			return result
+		}
+	}
+
+	if req.HikeID == 0 {
+		return fail(models.BulkStatusInvalid, "hike_id is required")
+	}
+	if err := validateIncident(&req); err != nil {
+		return fail(models.BulkStatusInvalid, err.Error())
+	}
+
+	if _, ok := hikes[req.HikeID]; !ok {
+		h, err := db.GetHikeByID(req.HikeID)
+		if err != nil {
+			if strings.Contains(err.Error(), "no rows") {
+				return fail(models.BulkStatusHikeNotFound, "hike not found")
+			}
+			return fail(models.BulkStatusError, err.Error())
+		}
+		hikes[req.HikeID] = h
+	}
+
+	incident, err := db.CreateIncident(req.HikeID, req, CurrentUser(r).ID, deviceID)
+	if err != nil {
+		if errors.Is(err, db.ErrIncidentPerson) {
+			return fail(models.BulkStatusInvalid, err.Error())
+		}
+		return fail(models.BulkStatusError, err.Error())
+	}
+
+	recordAudit(r, "create", "incident", incident.ID, nil, incident)
+	result.Status = models.BulkStatusCreated
+	result.Incident = incident
+	return result
+}
@@ -8,8 +8,6 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/skip2/go-qrcode"
-
 	"trailcall/db"
 	"trailcall/models"
 )
@@ -19,6 +17,9 @@ func HandleMembers(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		listMembers(w, r)
 	case http.MethodPost:
+		if !requirePermission(w, r, PermEditMembers) {
+			return
+		}
 		createMember(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -34,6 +35,16 @@ func HandleMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if parts[0] == "cards.pdf" {
+		HandleMemberCardsPDF(w, r)
+		return
+	}
+
+	if parts[0] == "duplicates" {
+		listDuplicates(w, r)
+		return
+	}
+
 	// Check if it's a QR request: /api/members/{id}/qr
 	if len(parts) == 2 && parts[1] == "qr" {
 		id, err := strconv.ParseInt(parts[0], 10, 64)
@@ -51,12 +62,55 @@ func HandleMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) == 2 && parts[1] == "emergency" {
+		HandleMemberEmergencyInfo(w, r, id)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "revoke-card" {
+		revokeCard(w, r, id)
+		return
+	}
+
+	if len(parts) == 3 && parts[1] == "wallet" {
+		HandleMemberWallet(w, r, id, parts[2])
+		return
+	}
+
+	if len(parts) >= 2 && parts[1] == "memberships" {
+		HandleMemberships(w, r, id, parts[2:])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "wallet-link" {
+		switch r.Method {
+		case http.MethodPost:
+			createWalletLink(w, r, id)
+		case http.MethodDelete:
+			deleteWalletLinks(w, r, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "merge" {
+		mergeMember(w, r, id)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		getMember(w, r, id)
 	case http.MethodPut:
+		if !requirePermission(w, r, PermEditMembers) {
+			return
+		}
 		updateMember(w, r, id)
 	case http.MethodDelete:
+		if !requirePermission(w, r, PermDeleteMembers) {
+			return
+		}
 		deleteMember(w, r, id)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -99,6 +153,17 @@ func createMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.CustomFields, err = checkFieldValues(fields, req.CustomFields, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	member, err := db.CreateMember(req)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint") {
@@ -109,6 +174,8 @@ func createMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAudit(r, "create", "member", member.ID, nil, member)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(member)
@@ -121,35 +188,94 @@ func updateMember(w http.ResponseWriter, r *http.Request, id int64) {
 		return
 	}
 
+	before, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	// Required fields are only enforced when custom fields are being edited,
+	// so members added before a field was defined can still be updated
+	if req.CustomFields != nil {
+		fields, err := db.GetCustomFields()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.CustomFields, err = checkFieldValues(fields, req.CustomFields, before.CustomFields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	member, err := db.UpdateMember(id, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, "update", "member", id, before, member)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(member)
 }
 
 func deleteMember(w http.ResponseWriter, r *http.Request, id int64) {
+	before, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.DeleteMember(id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	after, _ := db.GetMemberByID(id)
+	recordAudit(r, "delete", "member", id, before, after)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// generateQR handles GET /api/members/{id}/qr; see barcode.go for the
+// format, size, ec and output options. The code is a signed card payload, so
+// it needs the same permission as printing cards.
 func generateQR(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	opts, err := parseBarcodeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	member, err := db.GetMemberByID(id)
 	if err != nil {
 		http.Error(w, "Member not found", http.StatusNotFound)
 		return
 	}
 
-	// Generate QR code with membership number (e.g., "TC-001" or "CHC-001")
-	png, err := qrcode.Encode(member.MembershipNumber, qrcode.Medium, 256)
+	// Signed payload when a card key is set, otherwise the membership number (e.g., "TC-001" or "CHC-001")
+	code, err := encodeBarcode(db.CardPayload(member), opts)
 	if err != nil {
-		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		http.Error(w, "Failed to generate code: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.svg {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Disposition", "inline; filename=\""+member.MembershipNumber+".svg\"")
+		w.Write(code.svg(opts.size))
+		return
+	}
+
+	png, err := code.png(opts.size)
+	if err != nil {
+		http.Error(w, "Failed to generate code", http.StatusInternalServerError)
 		return
 	}
 
@@ -158,6 +284,15 @@ func generateQR(w http.ResponseWriter, r *http.Request, id int64) {
 	w.Write(png)
 }
 
+// HandleMembersImport handles POST /api/members/import with a CSV in the
+// "file" form field. Options come from the query string or form:
+//
+//	mode     insert (default) adds new members and skips existing ones,
+//	         upsert also updates existing ones, and sync additionally
+//	         deactivates active members missing from the file
+//	dry_run  true reports what would change without saving anything
+//
+// The whole import is saved in one transaction, and only if no row has an error.
 func HandleMembersImport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -170,6 +305,17 @@ func HandleMembersImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mode := r.FormValue("mode")
+	switch mode {
+	case "":
+		mode = models.ImportInsert
+	case models.ImportInsert, models.ImportUpsert, models.ImportSync:
+	default:
+		http.Error(w, "mode must be insert, upsert or sync", http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+
 	file, _, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "No file uploaded", http.StatusBadRequest)
@@ -178,6 +324,7 @@ func HandleMembersImport(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // short rows are reported, not fatal
 
 	// Read header row
 	header, err := reader.Read()
@@ -186,109 +333,78 @@ func HandleMembersImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Map column names to indices
-	colMap := make(map[string]int)
-	for i, col := range header {
-		colMap[strings.ToLower(strings.TrimSpace(col))] = i
-	}
-
-	// Required columns
-	numCol, hasNum := colMap["membership_number"]
-	if !hasNum {
-		numCol, hasNum = colMap["membership number"]
-	}
-	if !hasNum {
-		numCol, hasNum = colMap["number"]
-	}
-
-	firstCol, hasFirst := colMap["first_name"]
-	if !hasFirst {
-		firstCol, hasFirst = colMap["first name"]
-	}
-	if !hasFirst {
-		firstCol, hasFirst = colMap["firstname"]
-	}
-
-	lastCol, hasLast := colMap["last_name"]
-	if !hasLast {
-		lastCol, hasLast = colMap["last name"]
-	}
-	if !hasLast {
-		lastCol, hasLast = colMap["lastname"]
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if !hasLast {
-		lastCol, hasLast = colMap["surname"]
+	cols, err := parseImportHeader(header, fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	if !hasNum || !hasFirst || !hasLast {
-		http.Error(w, "CSV must have columns: membership_number, first_name, last_name", http.StatusBadRequest)
+	members, err := db.GetAllMembers(false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Optional columns
-	emailCol, hasEmail := colMap["email"]
-	phoneCol, hasPhone := colMap["phone"]
-
-	var imported, skipped, errors int
-	var errorMsgs []string
-
+	plan := newImportPlan(mode, cols, fields, members)
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
+		line, _ := reader.FieldPos(0)
 		if err != nil {
-			errors++
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				line, err = parseErr.StartLine, parseErr.Err
+			}
+			plan.addError(models.ImportRowResult{Line: line}, err.Error())
 			continue
 		}
+		plan.addRow(line, record)
+	}
 
-		memberNum := strings.TrimSpace(record[numCol])
-		firstName := strings.TrimSpace(record[firstCol])
-		lastName := strings.TrimSpace(record[lastCol])
-
-		if memberNum == "" || firstName == "" || lastName == "" {
-			skipped++
-			continue
+	if mode == models.ImportSync {
+		// An empty file would otherwise deactivate everyone
+		if len(plan.seen) == 0 {
+			http.Error(w, "sync needs at least one member in the file", http.StatusBadRequest)
+			return
 		}
+		plan.planDeactivations()
+	}
 
-		// Check for duplicate
-		existing, _ := db.GetMemberByMembershipNumber(memberNum)
-		if existing != nil {
-			skipped++
-			continue
-		}
+	result := &plan.result
+	result.DryRun = dryRun
+	result.Imported = result.Created + result.Updated
 
-		req := models.CreateMemberRequest{
-			MembershipNumber: memberNum,
-			FirstName:        firstName,
-			LastName:         lastName,
+	if !dryRun && result.Errors == 0 {
+		deactivate := make([]int64, len(plan.deactivate))
+		for i, m := range plan.deactivate {
+			deactivate[i] = m.ID
+		}
+		created, err := db.ApplyMemberImport(plan.create, plan.update, deactivate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		result.Committed = true
 
-		if hasEmail && emailCol < len(record) {
-			req.Email = strings.TrimSpace(record[emailCol])
+		for _, id := range created {
+			member, _ := db.GetMemberByID(id)
+			recordAudit(r, "import", "member", id, nil, member)
 		}
-		if hasPhone && phoneCol < len(record) {
-			req.Phone = strings.TrimSpace(record[phoneCol])
+		for i, m := range plan.update {
+			member, _ := db.GetMemberByID(m.ID)
+			recordAudit(r, "import", "member", m.ID, plan.before[i], member)
 		}
-
-		_, err = db.CreateMember(req)
-		if err != nil {
-			errors++
-			errorMsgs = append(errorMsgs, memberNum+": "+err.Error())
-		} else {
-			imported++
+		for _, m := range plan.deactivate {
+			member, _ := db.GetMemberByID(m.ID)
+			recordAudit(r, "import", "member", m.ID, m, member)
 		}
 	}
 
-	response := map[string]interface{}{
-		"imported": imported,
-		"skipped":  skipped,
-		"errors":   errors,
-	}
-	if len(errorMsgs) > 0 {
-		response["error_messages"] = errorMsgs
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(result)
 }
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+var validMembershipTypes = map[string]bool{
+	models.MembershipAnnual:  true,
+	models.MembershipStudent: true,
+	models.MembershipFamily:  true,
+	models.MembershipLife:    true,
+}
+
+// HandleMemberships handles /api/members/{id}/memberships and
+// /api/members/{id}/memberships/{periodID}. parts is the path after "memberships".
+func HandleMemberships(w http.ResponseWriter, r *http.Request, memberID int64, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listMembershipPeriods(w, memberID)
+		case http.MethodPost:
+			if !requirePermission(w, r, PermEditMembers) {
+				return
+			}
+			renewMembership(w, r, memberID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid membership period ID", http.StatusBadRequest)
+		return
+	}
+	period, err := db.GetMembershipPeriodByID(id)
+	if err != nil || period.MemberID != memberID {
+		http.Error(w, "Membership period not found", http.StatusNotFound)
+		return
+	}
+	if err := db.DeleteMembershipPeriod(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "delete", "membership_period", id, period, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listMembershipPeriods(w http.ResponseWriter, memberID int64) {
+	if _, err := db.GetMemberByID(memberID); err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	periods, err := db.GetMembershipPeriods(memberID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if periods == nil {
+		periods = []models.MembershipPeriod{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(periods)
+}
+
+// renewMembership adds a period to a member's history; see
+// models.RenewMembershipRequest for the defaults
+func renewMembership(w http.ResponseWriter, r *http.Request, memberID int64) {
+	var req models.RenewMembershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetMemberByID(memberID); err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	periods, err := db.GetMembershipPeriods(memberID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	periodType, start, end, err := renewalPeriod(req, periods, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	period, err := db.CreateMembershipPeriod(memberID, periodType, start, end, CurrentUser(r).ID)
+	if err != nil {
+		if errors.Is(err, db.ErrPeriodOverlap) {
+			writeJSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "renew_membership", "member", memberID, nil, period)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(period)
+}
+
+// renewalPeriod fills in a renewal's type and dates from the member's
+// latest period (periods are latest first)
+func renewalPeriod(req models.RenewMembershipRequest, periods []models.MembershipPeriod, now time.Time) (string, string, string, error) {
+	const layout = "2006-01-02"
+	today := now.Format(layout)
+
+	periodType := strings.ToLower(strings.TrimSpace(req.Type))
+	if periodType == "" {
+		periodType = models.MembershipAnnual
+		if len(periods) > 0 {
+			periodType = periods[0].Type
+		}
+	}
+	if !validMembershipTypes[periodType] {
+		return "", "", "", errors.New("type must be annual, student, family or life")
+	}
+
+	start := req.StartDate
+	if start == "" {
+		// Renewing early carries on from the current period
+		start = today
+		if len(periods) > 0 && periods[0].EndDate >= today {
+			if end, err := time.Parse(layout, periods[0].EndDate); err == nil {
+				start = end.AddDate(0, 0, 1).Format(layout)
+			}
+		}
+	}
+	startTime, err := time.Parse(layout, start)
+	if err != nil {
+		return "", "", "", errors.New("start_date must be YYYY-MM-DD")
+	}
+
+	if periodType == models.MembershipLife {
+		if req.EndDate != "" {
+			return "", "", "", errors.New("life memberships have no end_date")
+		}
+		return periodType, start, "", nil
+	}
+
+	end := req.EndDate
+	if end == "" {
+		end = startTime.AddDate(1, 0, -1).Format(layout)
+	}
+	if _, err := time.Parse(layout, end); err != nil {
+		return "", "", "", errors.New("end_date must be YYYY-MM-DD")
+	}
+	if end < start {
+		return "", "", "", errors.New("end_date is before start_date")
+	}
+	return periodType, start, end, nil
+}
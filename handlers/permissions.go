@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"trailcall/models"
+)
+
+// Permission names a single action a role may be allowed to take
+type Permission string
+
+const (
+	PermViewReports       Permission = "view_reports"        // read members, hikes, check-ins and reports
+	PermCheckin           Permission = "checkin"             // check members in, undo check-ins, toggle leader/sweeper
+	PermManualCheckin     Permission = "manual_checkin"      // check a member in by number without verifying their card
+	PermManageHikes       Permission = "manage_hikes"        // create, edit and close hikes, close RSVPs
+	PermReopenHikes       Permission = "reopen_hikes"        // reopen closed hikes
+	PermManageActivities  Permission = "manage_activities"   // create activities and assign participants
+	PermEditMembers       Permission = "edit_members"        // add and edit individual members
+	PermViewEmergencyInfo Permission = "view_emergency_info" // see emergency contacts and medical notes on the roster of a hike they lead
+	PermAssignLeaders     Permission = "assign_leaders"      // choose which users lead a hike
+	PermDeleteMembers     Permission = "delete_members"      // deactivate members
+	PermImportMembers     Permission = "import_members"      // bulk-import members from CSV
+	PermReopenRSVPs       Permission = "reopen_rsvps"        // reopen RSVPs once closed
+	PermDeleteRSVPs       Permission = "delete_rsvps"        // remove RSVP records
+	PermBackup            Permission = "backup"              // download database backups
+	PermManageFields      Permission = "manage_fields"       // define custom member fields
+)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin: {
+		PermViewReports, PermCheckin, PermManualCheckin, PermManageHikes, PermManageActivities,
+		PermEditMembers, PermViewEmergencyInfo, PermDeleteMembers, PermImportMembers, PermReopenRSVPs, PermDeleteRSVPs,
+		PermReopenHikes, PermBackup, PermManageFields, PermAssignLeaders,
+	},
+	models.RoleLeader: {
+		PermViewReports, PermCheckin, PermManualCheckin, PermManageHikes, PermManageActivities, PermEditMembers,
+		PermViewEmergencyInfo,
+	},
+	models.RoleViewer: {
+		PermViewReports,
+	},
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPermission reports whether the authenticated user may perform perm
+func hasPermission(r *http.Request, perm Permission) bool {
+	user := CurrentUser(r)
+	if user == nil {
+		return false
+	}
+	// Read-only API tokens are limited to what a viewer can do
+	if token := CurrentAPIToken(r); token != nil && token.ReadOnly && !roleHasPermission(models.RoleViewer, perm) {
+		return false
+	}
+	return roleHasPermission(user.Role, perm)
+}
+
+// requirePermission writes a 403 and returns false if the user lacks perm
+func requirePermission(w http.ResponseWriter, r *http.Request, perm Permission) bool {
+	if hasPermission(r, perm) {
+		return true
+	}
+	writeJSONError(w, "Forbidden: requires "+string(perm)+" permission", http.StatusForbidden)
+	return false
+}
+
+// RequirePermission wraps a handler so only users with perm can reach it
+func RequirePermission(perm Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requirePermission(w, r, perm) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSONError sends an error body the frontend API client can parse
+func writeJSONError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for key, signed by parent and parentKey, or
+// self-signed when parent is nil
+func testCert(t *testing.T, name string, serial int64, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSignPKCS7Detached(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := testCert(t, "Test Root", 1, rootKey, nil, nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		sigAlg x509.SignatureAlgorithm
+	}{
+		{"rsa", rsaKey, x509.SHA256WithRSA},
+		{"ecdsa", ecKey, x509.ECDSAWithSHA256},
+	}
+	content := []byte(`{"manifest.json":"0123456789abcdef"}`)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := testCert(t, "Pass Signer", int64(100+i), tt.key, root, rootKey)
+			der, err := signPKCS7Detached(content, cert, tt.key, []*x509.Certificate{root})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ci pkcs7ContentInfo
+			if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 {
+				t.Fatalf("ContentInfo: %v, %d trailing bytes", err, len(rest))
+			}
+			if !ci.ContentType.Equal(oidSignedData) {
+				t.Fatalf("content type %v, want signedData", ci.ContentType)
+			}
+			var sd pkcs7SignedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+				t.Fatal(err)
+			}
+			if len(sd.ContentInfo.Content.Bytes) > 0 {
+				t.Error("detached signature embeds the content")
+			}
+
+			certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != 2 || !certs[0].Equal(cert) || !certs[1].Equal(root) {
+				t.Fatalf("got %d certificates, want the signer then the chain", len(certs))
+			}
+
+			if len(sd.SignerInfos) != 1 {
+				t.Fatalf("got %d signers, want 1", len(sd.SignerInfos))
+			}
+			si := sd.SignerInfos[0]
+			if !bytes.Equal(si.IssuerAndSerialNumber.Issuer.FullBytes, cert.RawIssuer) || si.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+				t.Error("signer info doesn't identify the signing certificate")
+			}
+
+			// The message digest attribute must match the content
+			var attrs []pkcs7Attribute
+			if _, err := asn1.UnmarshalWithParams(si.AuthenticatedAttributes.FullBytes, &attrs, "set,tag:0"); err != nil {
+				t.Fatal(err)
+			}
+			digest := sha256.Sum256(content)
+			found := false
+			for _, a := range attrs {
+				if !a.Type.Equal(oidMessageDigest) {
+					continue
+				}
+				var got []byte
+				if _, err := asn1.Unmarshal(a.Value.Bytes, &got); err != nil {
+					t.Fatal(err)
+				}
+				found = bytes.Equal(got, digest[:])
+			}
+			if !found {
+				t.Error("message digest attribute missing or wrong")
+			}
+
+			// The signature covers the attributes re-tagged as a SET
+			signed := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+			if err := cert.CheckSignature(tt.sigAlg, signed, si.EncryptedDigest); err != nil {
+				t.Errorf("signature doesn't verify: %v", err)
+			}
+
+			verifyWithOpenSSL(t, der, content, root)
+		})
+	}
+}
+
+// verifyWithOpenSSL checks the signature and chain with openssl cms, when
+// openssl is installed
+func verifyWithOpenSSL(t *testing.T, signature, content []byte, root *x509.Certificate) {
+	t.Helper()
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Log("openssl not found, skipping the openssl check")
+		return
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"signature": signature,
+		"content":   content,
+		"root.pem":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(openssl, "cms", "-verify", "-binary", "-inform", "DER",
+		"-in", "signature", "-content", "content", "-CAfile", "root.pem",
+		"-purpose", "any", "-out", os.DevNull)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("openssl cms -verify: %v\n%s", err, out)
+	}
+
+	// A changed payload must fail
+	if err := os.WriteFile(filepath.Join(dir, "content"), append(content, ' '), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(cmd.Args[0], cmd.Args[1:]...)
+	cmd.Dir = dir
+	if err := cmd.Run(); err == nil {
+		t.Error("openssl accepted the signature for different content")
+	}
+}
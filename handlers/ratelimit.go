@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login throttling settings. Each failure past the free allowance doubles
+// the lockout, up to a maximum. Failures are forgotten after a quiet period.
+//
+// Failures are counted per client address and per username, which slows
+// guessing one account's PIN from many addresses. Anyone can fail logins for
+// any username, so that lockout is kept short: it must not let an attacker
+// keep a leader out for long. Only usernames that exist are tracked, and
+// only up to maxTrackedClients addresses, so a spray can't grow the maps
+// without bound.
+const (
+	ipFreeAttempts       = 5
+	usernameFreeAttempts = 10
+	baseLockout          = 30 * time.Second
+	maxIPLockout         = time.Hour
+	maxUsernameLockout   = 5 * time.Minute
+	failureWindow        = time.Hour
+	maxTrackedClients    = 10000
+)
+
+// Global throttling. Every failed login takes a token from one shared bucket
+// that refills at a steady rate. Once it is empty, each login is delayed a
+// little more for every token it is overdrawn, up to maxGlobalDelay. This
+// slows a PIN spray across many usernames and addresses without locking
+// anyone out.
+const (
+	globalBurst     = 30
+	globalRefill    = 2 * time.Second
+	globalDelayStep = 250 * time.Millisecond
+	maxGlobalDelay  = 5 * time.Second
+)
+
+// attemptState tracks failed logins for one client address or username
+type attemptState struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type loginLimiter struct {
+	mu        sync.Mutex
+	ips       map[string]*attemptState
+	usernames map[string]*attemptState
+
+	// tokens is the shared failure bucket as of refilled. It goes negative
+	// while logins are being delayed.
+	tokens   float64
+	refilled time.Time
+}
+
+var limiter = &loginLimiter{
+	ips:       make(map[string]*attemptState),
+	usernames: make(map[string]*attemptState),
+	tokens:    globalBurst,
+	refilled:  time.Now(),
+}
+
+// lockoutFor returns how long to lock out after the given number of failures
+func lockoutFor(failures, freeAttempts int, maxLockout time.Duration) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	d := baseLockout * time.Duration(math.Pow(2, float64(failures-freeAttempts)))
+	if d <= 0 || d > maxLockout {
+		return maxLockout
+	}
+	return d
+}
+
+// limiterKey normalises a username the way logins look it up
+func limiterKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// waitFor returns how long until s is no longer locked out
+func waitFor(s *attemptState, now time.Time) time.Duration {
+	if s == nil || !s.lockedUntil.After(now) {
+		return 0
+	}
+	return s.lockedUntil.Sub(now)
+}
+
+// retryAfter returns how long the client must wait before trying again
+func (l *loginLimiter) retryAfter(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return waitFor(l.ips[ip], time.Now())
+}
+
+// retryAfterUsername returns how long before the username can be tried again
+func (l *loginLimiter) retryAfterUsername(username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return waitFor(l.usernames[limiterKey(username)], time.Now())
+}
+
+// globalDelay returns how long to hold a login while the shared bucket is
+// overdrawn
+func (l *loginLimiter) globalDelay() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if l.tokens >= 0 {
+		return 0
+	}
+	return min(time.Duration(-l.tokens*float64(globalDelayStep)), maxGlobalDelay)
+}
+
+// refill adds the tokens earned since the last refill
+func (l *loginLimiter) refill(now time.Time) {
+	l.tokens = min(l.tokens+float64(now.Sub(l.refilled))/float64(globalRefill), globalBurst)
+	l.refilled = now
+}
+
+// recordFailure counts a failed login and starts a lockout once the free
+// attempts are used up. knownUser says whether the username exists; unknown
+// usernames only count against the client and the shared bucket.
+func (l *loginLimiter) recordFailure(ip, username string, knownUser bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.refill(now)
+	// The deficit is capped so the bucket recovers soon after a spray stops
+	if floor := -float64(maxGlobalDelay / globalDelayStep); l.tokens-1 >= floor {
+		l.tokens--
+		if l.tokens < 0 && l.tokens >= -1 {
+			log.Printf("Login throttling: failed logins exceeded the global limit, delaying logins")
+		}
+	}
+
+	s, ok := l.ips[ip]
+	if !ok && len(l.ips) < maxTrackedClients {
+		s = &attemptState{}
+		l.ips[ip] = s
+	}
+	if s != nil {
+		if lockout := s.fail(now, ipFreeAttempts, maxIPLockout); lockout > 0 {
+			log.Printf("Login lockout: ip=%s username=%q failures=%d locked for %s", ip, username, s.failures, lockout)
+		}
+	}
+
+	if !knownUser {
+		return
+	}
+	key := limiterKey(username)
+	u, ok := l.usernames[key]
+	if !ok {
+		u = &attemptState{}
+		l.usernames[key] = u
+	}
+	if lockout := u.fail(now, usernameFreeAttempts, maxUsernameLockout); lockout > 0 {
+		log.Printf("Login lockout: username=%q failures=%d locked for %s", key, u.failures, lockout)
+	}
+}
+
+// recordSuccess clears the failure history for a client and username
+func (l *loginLimiter) recordSuccess(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.ips, ip)
+	delete(l.usernames, limiterKey(username))
+}
+
+// StartLoginLimiterSweeper periodically forgets clients and usernames that
+// have stopped failing
+func StartLoginLimiterSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			limiter.mu.Lock()
+			limiter.prune(now)
+			limiter.mu.Unlock()
+		}
+	}()
+}
+
+// prune forgets clients and usernames that have been quiet for longer than
+// the failure window
+func (l *loginLimiter) prune(now time.Time) {
+	for _, states := range []map[string]*attemptState{l.ips, l.usernames} {
+		for key, s := range states {
+			if now.Sub(s.lastFailure) > failureWindow && now.After(s.lockedUntil) {
+				delete(states, key)
+			}
+		}
+	}
+}
+
+func (s *attemptState) fail(now time.Time, freeAttempts int, maxLockout time.Duration) time.Duration {
+	s.failures++
+	s.lastFailure = now
+	lockout := lockoutFor(s.failures, freeAttempts, maxLockout)
+	if lockout > 0 {
+		s.lockedUntil = now.Add(lockout)
+	}
+	return lockout
+}
+
+// writeTooManyAttempts sends a 429 with a Retry-After header
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSONError(w, fmt.Sprintf("Too many login attempts. Try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
+
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+)
+
+// getTrustedProxies parses TRAILCALL_TRUSTED_PROXIES, a comma-separated list
+// of IPs or CIDRs. Defaults to loopback, where cloudflared connects from.
+func getTrustedProxies() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		value := os.Getenv("TRAILCALL_TRUSTED_PROXIES")
+		if value == "" {
+			value = "127.0.0.0/8,::1"
+		}
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if !strings.Contains(entry, "/") {
+				if strings.Contains(entry, ":") {
+					entry += "/128"
+				} else {
+					entry += "/32"
+				}
+			}
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				log.Printf("Ignoring invalid trusted proxy %q: %v", entry, err)
+				continue
+			}
+			trustedProxies = append(trustedProxies, network)
+		}
+	})
+	return trustedProxies
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range getTrustedProxies() {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the real client. Forwarding headers are
+// only believed when the request arrived from a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(host)) {
+		return host
+	}
+
+	if cf := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); net.ParseIP(cf) != nil {
+		return cf
+	}
+
+	// Walk X-Forwarded-For from the right, skipping our own proxies
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				break
+			}
+			if !isTrustedProxy(ip) || i == 0 {
+				return hop
+			}
+		}
+	}
+	return host
+}
@@ -30,6 +30,16 @@ func HandleReports(w http.ResponseWriter, r *http.Request) {
 		handleAllHikesReport(w, r)
 	case "attendance":
 		handleFullAttendanceReport(w, r)
+	case "incidents":
+		handleIncidentReport(w, r)
+	case "lapsed":
+		handleLapsedReport(w, r)
+	case "households":
+		handleHouseholdReport(w, r)
+	case "duplicates":
+		handleDuplicateReport(w, r)
+	case "members":
+		handleMembersExport(w, r)
 	default:
 		http.Error(w, "Unknown report type", http.StatusNotFound)
 	}
@@ -146,6 +156,17 @@ func exportHikeAttendanceCSV(w http.ResponseWriter, r *http.Request, hikeID int6
 		return
 	}
 
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	values, err := db.GetAllMemberFieldValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	filename := fmt.Sprintf("%s_%s_attendance.csv", hike.Date, strings.ReplaceAll(hike.Name, " ", "_"))
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
@@ -154,7 +175,7 @@ func exportHikeAttendanceCSV(w http.ResponseWriter, r *http.Request, hikeID int6
 	defer writer.Flush()
 
 	// Header
-	writer.Write([]string{"Membership Number", "First Name", "Last Name", "Type", "Activities"})
+	writer.Write(append([]string{"Membership Number", "First Name", "Last Name", "Type", "Activities"}, customFieldHeader(fields)...))
 
 	// Data - members
 	for _, c := range checkins {
@@ -179,7 +200,8 @@ func exportHikeAttendanceCSV(w http.ResponseWriter, r *http.Request, hikeID int6
 			typeStr += " (Sweeper)"
 		}
 
-		writer.Write([]string{c.MembershipNumber, firstName, lastName, typeStr, activityStr})
+		row := []string{c.MembershipNumber, firstName, lastName, typeStr, activityStr}
+		writer.Write(append(row, customFieldRow(fields, values[c.MemberID])...))
 	}
 
 	// Data - guests
@@ -187,7 +209,8 @@ func exportHikeAttendanceCSV(w http.ResponseWriter, r *http.Request, hikeID int6
 		if r.CheckedIn && r.MemberID == nil {
 			activities, _ := db.GetActivitiesForRSVP(r.ID)
 			activityStr := strings.Join(activities, ", ")
-			writer.Write([]string{"", r.GuestName, "", "Guest", activityStr})
+			row := []string{"", r.GuestName, "", "Guest", activityStr}
+			writer.Write(append(row, customFieldRow(fields, nil)...))
 		}
 	}
 }
@@ -235,6 +258,16 @@ func handleFullAttendanceReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	fields, err := db.GetCustomFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	values, err := db.GetAllMemberFieldValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	filename := fmt.Sprintf("attendance_%s.csv", year)
 	w.Header().Set("Content-Type", "text/csv")
@@ -244,13 +277,13 @@ func handleFullAttendanceReport(w http.ResponseWriter, r *http.Request) {
 	defer writer.Flush()
 
 	// Header
-	writer.Write([]string{"Date", "Hike Name", "Location", "Membership Number", "First Name", "Last Name", "Check-in Time", "Activities"})
+	writer.Write(append([]string{"Date", "Hike Name", "Location", "Membership Number", "First Name", "Last Name", "Check-in Time", "Activities"}, customFieldHeader(fields)...))
 
 	// Data
 	for _, rec := range records {
 		activities, _ := db.GetActivitiesForCheckin(rec.CheckinID)
 		activityStr := strings.Join(activities, ", ")
-		writer.Write([]string{
+		row := []string{
 			rec.HikeDate,
 			rec.HikeName,
 			rec.HikeLocation,
@@ -259,6 +292,130 @@ func handleFullAttendanceReport(w http.ResponseWriter, r *http.Request) {
 			rec.LastName,
 			rec.CheckedInAt.Format("15:04:05"),
 			activityStr,
+		}
+		writer.Write(append(row, customFieldRow(fields, values[rec.MemberID])...))
+	}
+}
+
+// handleIncidentReport exports every incident for a year as CSV:
+// /api/reports/incidents?year=2025
+func handleIncidentReport(w http.ResponseWriter, r *http.Request) {
+	year := r.URL.Query().Get("year")
+	if year == "" {
+		year = fmt.Sprintf("%d", time.Now().Year())
+	}
+
+	incidents, err := db.GetIncidentsForYear(year)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("incidents_%s.csv", year)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	writer.Write([]string{"Date", "Hike Name", "Occurred At", "Severity", "Description", "Actions Taken", "People Involved", "Reported By", "Logged At"})
+
+	// Data
+	for _, i := range incidents {
+		occurredAt := ""
+		if i.OccurredAt != nil {
+			occurredAt = i.OccurredAt.Format("2006-01-02 15:04")
+		}
+		var people []string
+		for _, p := range i.People {
+			people = append(people, fmt.Sprintf("%s (%s)", p.Name, p.MembershipNumber))
+		}
+		writer.Write([]string{
+			i.HikeDate,
+			i.HikeName,
+			occurredAt,
+			i.Severity,
+			i.Description,
+			i.ActionsTaken,
+			strings.Join(people, ", "),
+			i.ReportedBy,
+			i.CreatedAt.Format("2006-01-02 15:04"),
+		})
+	}
+}
+
+// handleLapsedReport exports, as CSV, every check-in in a year by a member
+// whose membership didn't cover the hike date: /api/reports/lapsed?year=2025
+func handleLapsedReport(w http.ResponseWriter, r *http.Request) {
+	year := r.URL.Query().Get("year")
+	if year == "" {
+		year = fmt.Sprintf("%d", time.Now().Year())
+	}
+
+	records, err := db.GetLapsedAttendanceForYear(year)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("lapsed_%s.csv", year)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	writer.Write([]string{"Membership Number", "First Name", "Last Name", "Email", "Date", "Hike Name", "Lapsed Since"})
+
+	// Data
+	for _, rec := range records {
+		writer.Write([]string{
+			rec.MembershipNumber,
+			rec.FirstName,
+			rec.LastName,
+			rec.Email,
+			rec.HikeDate,
+			rec.HikeName,
+			rec.LapsedSince,
+		})
+	}
+}
+
+// handleHouseholdReport exports each household's attendance for a year as
+// CSV: /api/reports/households?year=2025
+func handleHouseholdReport(w http.ResponseWriter, r *http.Request) {
+	year := r.URL.Query().Get("year")
+	if year == "" {
+		year = fmt.Sprintf("%d", time.Now().Year())
+	}
+
+	records, err := db.GetHouseholdAttendanceForYear(year)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("households_%s.csv", year)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	// Header
+	writer.Write([]string{"Household", "Primary Contact", "Members", "Hikes Attended", "Check-ins", "Last Hike"})
+
+	// Data
+	for _, rec := range records {
+		writer.Write([]string{
+			rec.Name,
+			rec.PrimaryContact,
+			strconv.Itoa(rec.Members),
+			strconv.Itoa(rec.HikesAttended),
+			strconv.Itoa(rec.Checkins),
+			rec.LastHike,
 		})
 	}
 }
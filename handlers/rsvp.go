@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -95,27 +96,36 @@ func submitRSVP(w http.ResponseWriter, r *http.Request, hikeID int64) {
 
 	// High confidence match (>= 80%)
 	if member != nil && score >= 80 {
-		err := db.CreateRSVPForMember(hikeID, member.ID)
-		if err != nil {
-			// Likely duplicate
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(models.RSVPResponse{
-				Success:      false,
-				Message:      "You're already registered for this hike",
-				MatchedName:  member.FirstName + " " + member.LastName,
-				MemberNumber: member.MembershipNumber,
-			})
-			return
+		response := models.RSVPResponse{
+			MatchedName:  member.FirstName + " " + member.LastName,
+			MemberNumber: member.MembershipNumber,
+		}
+		registered := rsvpMember(hikeID, member)
+
+		// Household members named on the form are registered too
+		if len(req.Household) > 0 {
+			others, unmatched, err := db.MatchHouseholdMembers(member, req.Household)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for i := range others {
+				if rsvpMember(hikeID, &others[i]) {
+					registered = true
+				}
+				response.Household = append(response.Household, others[i].FirstName+" "+others[i].LastName)
+			}
+			response.Unmatched = unmatched
 		}
 
+		if !registered {
+			response.Message = "You're already registered for this hike"
+		} else {
+			response.Success = true
+			response.Message = "RSVP confirmed!"
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(models.RSVPResponse{
-			Success:      true,
-			Message:      "RSVP confirmed!",
-			MatchedName:  member.FirstName + " " + member.LastName,
-			MemberNumber: member.MembershipNumber,
-			IsGuest:      false,
-		})
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
@@ -132,6 +142,11 @@ func submitRSVP(w http.ResponseWriter, r *http.Request, hikeID int64) {
 		return
 	}
 
+	if rsvpID, err := db.GetRSVPIDForGuest(hikeID, guestName); err == nil {
+		rsvp, _ := db.GetRSVPByID(*rsvpID)
+		recordAuditAs(nil, "create", "rsvp", *rsvpID, nil, rsvp)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.RSVPResponse{
 		Success:     true,
@@ -141,6 +156,21 @@ func submitRSVP(w http.ResponseWriter, r *http.Request, hikeID int64) {
 	})
 }
 
+// rsvpMember registers a member for a hike, reporting false if they already were
+func rsvpMember(hikeID int64, member *models.Member) bool {
+	if _, err := db.GetRSVPIDForMember(hikeID, member.ID); err == nil {
+		return false
+	}
+	if err := db.CreateRSVPForMember(hikeID, member.ID); err != nil {
+		return false
+	}
+	if rsvpID, err := db.GetRSVPIDForMember(hikeID, member.ID); err == nil {
+		rsvp, _ := db.GetRSVPByID(*rsvpID)
+		recordAuditAs(nil, "create", "rsvp", *rsvpID, nil, rsvp)
+	}
+	return true
+}
+
 // HandleHikeRSVPs handles admin viewing of RSVPs (auth required)
 func HandleHikeRSVPs(w http.ResponseWriter, r *http.Request, hikeID int64) {
 	switch r.Method {
@@ -165,11 +195,20 @@ func HandleCloseRSVPs(w http.ResponseWriter, r *http.Request, hikeID int64) {
 		return
 	}
 
+	before, err := db.GetHikeByID(hikeID)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.CloseRSVPs(hikeID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	after, _ := db.GetHikeByID(hikeID)
+	recordAudit(r, "close_rsvps", "hike", hikeID, before, after)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -181,11 +220,20 @@ func HandleOpenRSVPs(w http.ResponseWriter, r *http.Request, hikeID int64) {
 		return
 	}
 
+	before, err := db.GetHikeByID(hikeID)
+	if err != nil {
+		http.Error(w, "Hike not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.OpenRSVPs(hikeID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	after, _ := db.GetHikeByID(hikeID)
+	recordAudit(r, "open_rsvps", "hike", hikeID, before, after)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -197,11 +245,27 @@ func HandleRSVPCheckin(w http.ResponseWriter, r *http.Request, rsvpID int64) {
 		return
 	}
 
+	if !requirePermission(w, r, PermCheckin) {
+		return
+	}
+
+	before, err := db.GetRSVPByID(rsvpID)
+	if err != nil {
+		http.Error(w, "RSVP not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.CheckInRSVP(rsvpID); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	after, _ := db.GetRSVPByID(rsvpID)
+	recordAudit(r, "checkin", "rsvp", rsvpID, before, after)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -213,11 +277,69 @@ func HandleRSVPUndoCheckin(w http.ResponseWriter, r *http.Request, rsvpID int64)
 		return
 	}
 
+	if !requirePermission(w, r, PermCheckin) {
+		return
+	}
+
+	before, err := db.GetRSVPByID(rsvpID)
+	if err != nil {
+		http.Error(w, "RSVP not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.UndoRSVPCheckin(rsvpID); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	after, _ := db.GetRSVPByID(rsvpID)
+	recordAudit(r, "undo_checkin", "rsvp", rsvpID, before, after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HandleRSVPCheckout checks out (POST) or undoes the checkout (DELETE) of the
+// person behind an RSVP, so guests can be accounted for at the end of a hike
+func HandleRSVPCheckout(w http.ResponseWriter, r *http.Request, rsvpID int64) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermCheckin) {
+		return
+	}
+
+	before, err := db.GetRSVPByID(rsvpID)
+	if err != nil {
+		http.Error(w, "RSVP not found", http.StatusNotFound)
+		return
+	}
+
+	checkedOut := r.Method == http.MethodPost
+	if err := db.SetRSVPCheckedOut(rsvpID, checkedOut); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrNotCheckedIn) {
+			writeJSONError(w, "RSVP is not checked in", http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	action := "checkout"
+	if !checkedOut {
+		action = "undo_checkout"
+	}
+	after, _ := db.GetRSVPByID(rsvpID)
+	recordAudit(r, action, "rsvp", rsvpID, before, after)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -229,10 +351,25 @@ func HandleDeleteRSVP(w http.ResponseWriter, r *http.Request, rsvpID int64) {
 		return
 	}
 
+	if !requirePermission(w, r, PermDeleteRSVPs) {
+		return
+	}
+
+	before, err := db.GetRSVPByID(rsvpID)
+	if err != nil {
+		http.Error(w, "RSVP not found", http.StatusNotFound)
+		return
+	}
+
 	if err := db.DeleteRSVP(rsvpID); err != nil {
+		if writeHikeClosed(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, "delete", "rsvp", rsvpID, before, nil)
+
 	w.WriteHeader(http.StatusNoContent)
 }
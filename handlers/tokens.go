@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// API tokens let scripts authenticate with "Authorization: Bearer <token>".
+// They act as the user who created them, optionally limited to read-only.
+
+const apiTokenPrefix = "tc_"
+
+func generateAPIToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return apiTokenPrefix + hex.EncodeToString(b)
+}
+
+// HandleTokens handles /api/tokens and /api/tokens/{id}
+func HandleTokens(w http.ResponseWriter, r *http.Request) {
+	// Tokens can only be managed from a logged-in browser session, not by other tokens
+	if CurrentAPIToken(r) != nil {
+		writeJSONError(w, "API tokens cannot manage tokens", http.StatusForbidden)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listAPITokens(w, r)
+		case http.MethodPost:
+			createAPIToken(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revokeAPIToken(w, r, id)
+}
+
+// listAPITokens returns the user's own tokens; admins see everyone's
+func listAPITokens(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	userID := user.ID
+	if user.Role == models.RoleAdmin {
+		userID = 0
+	}
+
+	tokens, err := db.GetAPITokens(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func createAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	user := CurrentUser(r)
+	raw := generateAPIToken()
+	token, err := db.CreateAPIToken(user.ID, req.Name, hashToken(raw), req.ReadOnly)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "create", "api_token", token.ID, nil, token)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateAPITokenResponse{APIToken: *token, Token: raw})
+}
+
+func revokeAPIToken(w http.ResponseWriter, r *http.Request, id int64) {
+	before, err := db.GetAPITokenByID(id)
+	if err != nil {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	user := CurrentUser(r)
+	if before.UserID != user.ID && user.Role != models.RoleAdmin {
+		writeJSONError(w, "Forbidden: token belongs to another user", http.StatusForbidden)
+		return
+	}
+
+	if err := db.RevokeAPIToken(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	after, _ := db.GetAPITokenByID(id)
+	recordAudit(r, "revoke", "api_token", id, before, after)
+
+	w.WriteHeader(http.StatusNoContent)
+}
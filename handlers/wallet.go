@@ -0,0 +1,527 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"trailcall/db"
+	"trailcall/models"
+)
+
+// Wallet passes
+//
+// A member's card can be added to Apple Wallet as a signed .pkpass, or to
+// Google Wallet through a signed "save to wallet" JWT. Both carry the same
+// QR payload as the printed card, so they check in the same way and stop
+// working when the card is revoked.
+
+// Self-service wallet links are valid for this long
+const walletLinkLifetime = 30 * 24 * time.Hour
+
+// The club colour, used on both passes
+var walletBackground = color.RGBA{0x2d, 0x50, 0x16, 0xff}
+
+type appleWalletConfig struct {
+	cert       *x509.Certificate
+	key        crypto.Signer
+	wwdr       *x509.Certificate
+	passTypeID string
+	teamID     string
+}
+
+type googleWalletConfig struct {
+	clientEmail string
+	keyID       string
+	key         *rsa.PrivateKey
+	issuerID    string
+	classSuffix string
+}
+
+var (
+	appleWallet  *appleWalletConfig
+	googleWallet *googleWalletConfig
+)
+
+// SetAppleWalletConfig enables Apple Wallet passes. certPath and keyPath are
+// the PEM pass type certificate and its private key, wwdrPath Apple's PEM
+// WWDR intermediate certificate.
+func SetAppleWalletConfig(certPath, keyPath, wwdrPath, passTypeID, teamID string) error {
+	if passTypeID == "" || teamID == "" {
+		return errors.New("pass type ID and team ID are required")
+	}
+	cert, err := readCertificate(certPath)
+	if err != nil {
+		return err
+	}
+	wwdr, err := readCertificate(wwdrPath)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return fmt.Errorf("%s: %w", keyPath, err)
+	}
+	appleWallet = &appleWalletConfig{cert: cert, key: key, wwdr: wwdr, passTypeID: passTypeID, teamID: teamID}
+	return nil
+}
+
+// SetGoogleWalletConfig enables Google Wallet passes. keyPath is a service
+// account's JSON key file; passes use the generic class issuerID.classSuffix,
+// which must already exist in the Google Pay & Wallet Console.
+func SetGoogleWalletConfig(keyPath, issuerID, classSuffix string) error {
+	if issuerID == "" {
+		return errors.New("issuer ID is required")
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	var account struct {
+		ClientEmail  string `json:"client_email"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return fmt.Errorf("%s: %w", keyPath, err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return fmt.Errorf("%s: not a service account key", keyPath)
+	}
+	key, err := parsePrivateKey([]byte(account.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("%s: %w", keyPath, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("%s: service account key is not RSA", keyPath)
+	}
+	googleWallet = &googleWalletConfig{
+		clientEmail: account.ClientEmail,
+		keyID:       account.PrivateKeyID,
+		key:         rsaKey,
+		issuerID:    issuerID,
+		classSuffix: classSuffix,
+	}
+	return nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate found", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parsePrivateKey reads a PEM private key in PKCS#8, PKCS#1 or SEC 1 form
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// HandleMemberWallet handles GET /api/members/{id}/wallet/apple and
+// GET /api/members/{id}/wallet/google. A pass carries a signed card, so only
+// users who can issue cards may download one.
+func HandleMemberWallet(w http.ResponseWriter, r *http.Request, id int64, kind string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	member, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	switch kind {
+	case "apple":
+		serveApplePass(w, member)
+	case "google":
+		jwt, ok := googleSaveJWT(w, member)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"jwt":      jwt,
+			"save_url": googleSaveURL(jwt),
+		})
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// createWalletLink handles POST /api/members/{id}/wallet-link. The link lets
+// the member add their card to a wallet themselves, without logging in.
+func createWalletLink(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	if appleWallet == nil && googleWallet == nil {
+		writeJSONError(w, "Wallet passes are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	member, err := db.GetMemberByID(id)
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if !member.Active {
+		http.Error(w, "Member is not active", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(walletLinkLifetime).UTC()
+
+	if err := db.CreateWalletLink(id, hashToken(token), CurrentUser(r).ID, expiresAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "create_wallet_link", "member", id, nil, map[string]interface{}{"expires_at": expiresAt})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        "/wallet/" + token,
+		"expires_at": expiresAt,
+	})
+}
+
+// deleteWalletLinks handles DELETE /api/members/{id}/wallet-link, cancelling
+// every link sent to the member, e.g. when one was sent to the wrong address
+func deleteWalletLinks(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePermission(w, r, PermEditMembers) {
+		return
+	}
+
+	if _, err := db.GetMemberByID(id); err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	removed, err := db.DeleteWalletLinks(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, "delete_wallet_links", "member", id, nil, map[string]int64{"removed": removed})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleWalletLink handles the public self-service pages: /wallet/{token}
+// offers the passes, /wallet/{token}/apple downloads the Apple pass and
+// /wallet/{token}/google redirects to Google Wallet (no auth required)
+func HandleWalletLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/wallet/"), "/")
+	w.Header().Set("Cache-Control", "no-store")
+
+	member, err := db.GetWalletLinkMember(hashToken(parts[0]))
+	if err != nil {
+		http.Error(w, "This link is not valid or has expired. Ask the club for a new one.", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		walletTemplate.Execute(w, map[string]interface{}{
+			"Club":   clubName(),
+			"Member": member,
+			"Token":  parts[0],
+			"Apple":  appleWallet != nil,
+			"Google": googleWallet != nil,
+		})
+		return
+	}
+
+	switch parts[1] {
+	case "apple":
+		serveApplePass(w, member)
+	case "google":
+		if jwt, ok := googleSaveJWT(w, member); ok {
+			http.Redirect(w, r, googleSaveURL(jwt), http.StatusFound)
+		}
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+var walletTemplate = template.Must(template.New("wallet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Club}} - Membership Card</title>
+<style>
+body { font-family: sans-serif; max-width: 24em; margin: 2em auto; padding: 0 1em; text-align: center; }
+h1 { font-size: 1.3em; color: #2d5016; }
+a.button { display: block; margin: 1em 0; padding: 0.8em; border-radius: 6px; background: #000; color: #fff; text-decoration: none; }
+a.google { background: #1a73e8; }
+</style>
+</head>
+<body>
+<h1>{{.Club}}</h1>
+<p>{{.Member.FirstName}} {{.Member.LastName}}<br>Membership number {{.Member.MembershipNumber}}</p>
+{{if .Apple}}<a class="button" href="/wallet/{{.Token}}/apple">Add to Apple Wallet</a>{{end}}
+{{if .Google}}<a class="button google" href="/wallet/{{.Token}}/google">Add to Google Wallet</a>{{end}}
+{{if not (or .Apple .Google)}}<p>Wallet passes are not available at the moment.</p>{{end}}
+</body>
+</html>
+`))
+
+func serveApplePass(w http.ResponseWriter, member *models.Member) {
+	if appleWallet == nil {
+		writeJSONError(w, "Apple Wallet passes are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	pass, err := buildApplePass(member, clubName())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.pkpass")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"membership_card.pkpass\"")
+	w.Write(pass)
+}
+
+type passField struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// buildApplePass assembles a signed .pkpass: pass.json, icons, a manifest of
+// their SHA-1 hashes, and a detached signature over the manifest
+func buildApplePass(member *models.Member, club string) ([]byte, error) {
+	pass := map[string]interface{}{
+		"formatVersion":      1,
+		"passTypeIdentifier": appleWallet.passTypeID,
+		"teamIdentifier":     appleWallet.teamID,
+		// A revoked card gets a new serial number, so the new pass doesn't replace the old silently
+		"serialNumber":     fmt.Sprintf("%s-v%d", member.MembershipNumber, member.CardVersion),
+		"organizationName": club,
+		"description":      club + " membership card",
+		"logoText":         club,
+		"foregroundColor":  "rgb(255, 255, 255)",
+		"labelColor":       "rgb(220, 230, 210)",
+		"backgroundColor":  fmt.Sprintf("rgb(%d, %d, %d)", walletBackground.R, walletBackground.G, walletBackground.B),
+		"generic": map[string]interface{}{
+			"primaryFields":   []passField{{Key: "name", Label: "MEMBER", Value: member.FirstName + " " + member.LastName}},
+			"secondaryFields": []passField{{Key: "number", Label: "MEMBERSHIP NO.", Value: member.MembershipNumber}},
+		},
+		"barcodes": []map[string]string{{
+			"format":          "PKBarcodeFormatQR",
+			"message":         db.CardPayload(member),
+			"messageEncoding": "iso-8859-1",
+			"altText":         member.MembershipNumber,
+		}},
+	}
+	passJSON, err := json.Marshal(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{"pass.json": passJSON}
+	for name, size := range map[string]int{"icon.png": 29, "icon@2x.png": 58, "icon@3x.png": 87} {
+		icon, err := walletIcon(size)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = icon
+	}
+
+	manifest := map[string]string{}
+	for name, data := range files {
+		sum := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	files["manifest.json"] = manifestJSON
+
+	signature, err := signPKCS7Detached(manifestJSON, appleWallet.cert, appleWallet.key, []*x509.Certificate{appleWallet.wwdr})
+	if err != nil {
+		return nil, err
+	}
+	files["signature"] = signature
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"pass.json", "icon.png", "icon@2x.png", "icon@3x.png", "manifest.json", "signature"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		f.Write(files[name])
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walletIcon draws the pass icon: a white peak on the club colour
+func walletIcon(size int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	// A triangle with its apex a fifth of the way down and its base on the bottom
+	top := size / 5
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, walletBackground)
+			if y >= top {
+				half := (y - top) * size / (2 * (size - top))
+				if x >= size/2-half && x <= size/2+half {
+					img.Set(x, y, white)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// googleSaveJWT builds the signed JWT for a member's Google Wallet pass,
+// writing a 503 and returning false if Google Wallet isn't configured
+func googleSaveJWT(w http.ResponseWriter, member *models.Member) (string, bool) {
+	if googleWallet == nil {
+		writeJSONError(w, "Google Wallet passes are not configured", http.StatusServiceUnavailable)
+		return "", false
+	}
+
+	localized := func(s string) map[string]interface{} {
+		return map[string]interface{}{
+			"defaultValue": map[string]string{"language": "en", "value": s},
+		}
+	}
+	object := map[string]interface{}{
+		// Object IDs are permanent, so each card version gets its own
+		"id":                 fmt.Sprintf("%s.member-%d-v%d", googleWallet.issuerID, member.ID, member.CardVersion),
+		"classId":            googleWallet.issuerID + "." + googleWallet.classSuffix,
+		"state":              "ACTIVE",
+		"cardTitle":          localized(clubName()),
+		"header":             localized(member.FirstName + " " + member.LastName),
+		"subheader":          localized("Member"),
+		"hexBackgroundColor": fmt.Sprintf("#%02x%02x%02x", walletBackground.R, walletBackground.G, walletBackground.B),
+		"barcode": map[string]string{
+			"type":          "QR_CODE",
+			"value":         db.CardPayload(member),
+			"alternateText": member.MembershipNumber,
+		},
+		"textModulesData": []map[string]string{{
+			"id":     "membership_number",
+			"header": "Membership No.",
+			"body":   member.MembershipNumber,
+		}},
+	}
+	claims := map[string]interface{}{
+		"iss": googleWallet.clientEmail,
+		"aud": "google",
+		"typ": "savetowallet",
+		"iat": time.Now().Unix(),
+		"payload": map[string]interface{}{
+			"genericObjects": []interface{}{object},
+		},
+	}
+
+	jwt, err := signJWT(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return jwt, true
+}
+
+// signJWT signs claims with the Google service account key (RS256)
+func signJWT(claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": googleWallet.keyID})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, googleWallet.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func googleSaveURL(jwt string) string {
+	return "https://pay.google.com/gp/v/save/" + jwt
+}
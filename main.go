@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"trailcall/db"
 	"trailcall/handlers"
@@ -43,7 +44,7 @@ func loadEnv() {
 	}
 }
 
-// handleRSVPRoutes handles /api/rsvps/{id}/checkin
+// handleRSVPRoutes handles /api/rsvps/{id}/checkin, /undo, /checkout and /delete
 func handleRSVPRoutes(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/rsvps/")
 	parts := strings.Split(path, "/")
@@ -65,6 +66,9 @@ func handleRSVPRoutes(w http.ResponseWriter, r *http.Request) {
 	case "undo":
 		handlers.HandleRSVPUndoCheckin(w, r, rsvpID)
 		return
+	case "checkout":
+		handlers.HandleRSVPCheckout(w, r, rsvpID)
+		return
 	case "delete":
 		handlers.HandleDeleteRSVP(w, r, rsvpID)
 		return
@@ -77,9 +81,18 @@ func main() {
 	loadEnv()
 	// Parse flags
 	genHash := flag.String("gen-hash", "", "Generate bcrypt hash for a PIN")
+	genKey := flag.Bool("gen-key", false, "Generate a key for TRAILCALL_ENCRYPTION_KEY or TRAILCALL_CARD_KEY")
 	port := flag.Int("port", 2468, "Port to listen on")
 	dbPath := flag.String("db", "trailcall.db", "Path to SQLite database")
 	resetDB := flag.Bool("reset-db", false, "Delete all data and reset database")
+	backupPath := flag.String("backup", "", "Write a backup of the database to this path (safe while the server runs)")
+	restorePath := flag.String("restore", "", "Replace the database with this backup file (stop the server first)")
+	migrateStatus := flag.Bool("migrate-status", false, "List applied and pending schema migrations without starting the server")
+	addUser := flag.String("add-user", "", "Create a user account with this username (use with -name, -pin and -role)")
+	disableUser := flag.String("disable-user", "", "Disable the leader account with this username")
+	userName := flag.String("name", "", "Display name for -add-user")
+	userPIN := flag.String("pin", "", "PIN for -add-user")
+	userRole := flag.String("role", "leader", "Role for -add-user: admin, leader or viewer")
 	flag.Parse()
 
 	// If generating a hash, do that and exit
@@ -88,11 +101,15 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("Set this as TRAILCALL_PIN_HASH:")
 		fmt.Println(hash)
 		return
 	}
 
+	if *genKey {
+		fmt.Println(db.GenerateEncryptionKey())
+		return
+	}
+
 	// If resetting database
 	if *resetDB {
 		if err := os.Remove(*dbPath); err != nil && !os.IsNotExist(err) {
@@ -102,9 +119,51 @@ func main() {
 		return
 	}
 
-	// Check for required environment variable
-	if os.Getenv("TRAILCALL_PIN_HASH") == "" {
-		log.Println("Warning: TRAILCALL_PIN_HASH not set. Run with -gen-hash <pin> to generate one.")
+	// If restoring, validate and swap in the backup before anything opens the database
+	if *restorePath != "" {
+		kept, err := db.Restore(*restorePath, *dbPath)
+		if err != nil {
+			log.Fatal("Restore failed:", err)
+		}
+		if kept != "" {
+			log.Println("Previous database kept at", kept)
+		}
+		log.Printf("Restored %s from %s. Pending migrations run on next start.", *dbPath, *restorePath)
+		return
+	}
+
+	// If backing up, copy the live database and exit
+	if *backupPath != "" {
+		if err := db.Open(*dbPath); err != nil {
+			log.Fatal("Failed to open database:", err)
+		}
+		if err := db.Backup(*backupPath); err != nil {
+			log.Fatal("Backup failed:", err)
+		}
+		log.Println("Backup written to", *backupPath)
+		return
+	}
+
+	// If checking migrations, report them without applying anything
+	if *migrateStatus {
+		if err := db.Open(*dbPath); err != nil {
+			log.Fatal("Failed to open database:", err)
+		}
+		statuses, err := db.GetMigrationStatus()
+		if err != nil {
+			log.Fatal("Failed to read migrations:", err)
+		}
+		pending := 0
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("  applied  %3d  %s  (%s)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("  pending  %3d  %s\n", s.Version, s.Name)
+				pending++
+			}
+		}
+		fmt.Printf("%d pending migration(s)\n", pending)
+		return
 	}
 
 	// Initialize database
@@ -113,6 +172,111 @@ func main() {
 	}
 	log.Println("Database initialized:", *dbPath)
 
+	// If managing user accounts, do that and exit
+	if *addUser != "" {
+		if *userName == "" || *userPIN == "" {
+			log.Fatal("-add-user requires -name and -pin")
+		}
+		if !handlers.IsValidRole(*userRole) {
+			log.Fatal("-role must be admin, leader or viewer")
+		}
+		hash, err := handlers.GeneratePINHash(*userPIN)
+		if err != nil {
+			log.Fatal(err)
+		}
+		user, err := db.CreateUser(*userName, *addUser, hash, *userRole)
+		if err != nil {
+			log.Fatal("Failed to create user:", err)
+		}
+		log.Printf("Created %s %s (%s)", user.Role, user.Username, user.Name)
+		return
+	}
+
+	if *disableUser != "" {
+		if err := db.SetUserActive(*disableUser, false); err != nil {
+			log.Fatal("Failed to disable user:", err)
+		}
+		log.Printf("Disabled user %s", *disableUser)
+		return
+	}
+
+	// Warn if nobody can log in yet
+	if count, err := db.CountActiveUsers(); err == nil && count == 0 {
+		log.Println("Warning: no active users. Run with -add-user <username> -name <name> -pin <pin> to create one.")
+	}
+
+	// Emergency contacts and medical notes are encrypted with this key
+	if key := os.Getenv("TRAILCALL_ENCRYPTION_KEY"); key != "" {
+		if err := db.SetEncryptionKey(key); err != nil {
+			log.Fatal("Invalid TRAILCALL_ENCRYPTION_KEY:", err)
+		}
+	} else {
+		log.Println("TRAILCALL_ENCRYPTION_KEY not set: emergency contacts and medical notes are disabled. Generate one with -gen-key.")
+	}
+
+	// Membership card QR codes are signed with this key
+	if key := os.Getenv("TRAILCALL_CARD_KEY"); key != "" {
+		if err := db.SetCardKey(key); err != nil {
+			log.Fatal("Invalid TRAILCALL_CARD_KEY:", err)
+		}
+	}
+	if v := os.Getenv("TRAILCALL_ACCEPT_PLAIN_CARDS"); v != "" {
+		accept, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("Invalid TRAILCALL_ACCEPT_PLAIN_CARDS:", v)
+		}
+		if !accept && !db.CardSigningEnabled() {
+			log.Fatal("TRAILCALL_ACCEPT_PLAIN_CARDS=false needs TRAILCALL_CARD_KEY")
+		}
+		db.SetAcceptPlainCards(accept)
+	}
+
+	// Wallet passes, if the club has Apple or Google Wallet credentials
+	if cert := os.Getenv("TRAILCALL_APPLE_PASS_CERT"); cert != "" {
+		err := handlers.SetAppleWalletConfig(cert, os.Getenv("TRAILCALL_APPLE_PASS_KEY"), os.Getenv("TRAILCALL_APPLE_WWDR_CERT"),
+			os.Getenv("TRAILCALL_APPLE_PASS_TYPE_ID"), os.Getenv("TRAILCALL_APPLE_TEAM_ID"))
+		if err != nil {
+			log.Fatal("Invalid Apple Wallet configuration:", err)
+		}
+	}
+	if key := os.Getenv("TRAILCALL_GOOGLE_WALLET_KEY"); key != "" {
+		suffix := os.Getenv("TRAILCALL_GOOGLE_WALLET_CLASS")
+		if suffix == "" {
+			suffix = "membership"
+		}
+		if err := handlers.SetGoogleWalletConfig(key, os.Getenv("TRAILCALL_GOOGLE_ISSUER_ID"), suffix); err != nil {
+			log.Fatal("Invalid Google Wallet configuration:", err)
+		}
+	}
+
+	// Clean up expired sessions in the background
+	handlers.StartSessionSweeper(time.Hour)
+	handlers.StartLoginLimiterSweeper(10 * time.Minute)
+
+	// Scheduled backups, if a directory is configured
+	if dir := os.Getenv("TRAILCALL_BACKUP_DIR"); dir != "" {
+		interval := 24 * time.Hour
+		if v := os.Getenv("TRAILCALL_BACKUP_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatal("Invalid TRAILCALL_BACKUP_INTERVAL:", v)
+			}
+			interval = d
+		}
+		keep := 7
+		if v := os.Getenv("TRAILCALL_BACKUP_KEEP"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				log.Fatal("Invalid TRAILCALL_BACKUP_KEEP:", v)
+			}
+			keep = n
+		}
+		if err := db.StartScheduledBackups(dir, interval, keep); err != nil {
+			log.Fatal("Failed to start scheduled backups:", err)
+		}
+		log.Printf("Backing up to %s every %s, keeping %d", dir, interval, keep)
+	}
+
 	// Set up routes
 	mux := http.NewServeMux()
 
@@ -120,21 +284,35 @@ func main() {
 	mux.HandleFunc("/api/auth", handlers.HandleLogin)
 	mux.HandleFunc("/api/auth/logout", handlers.HandleLogout)
 	mux.HandleFunc("/api/auth/check", handlers.HandleCheckAuth)
+	mux.Handle("/api/auth/logout-all", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleLogoutAll)))
 
 	// Public RSVP endpoint (no auth required)
 	mux.HandleFunc("/rsvp/", handlers.HandleRSVP)
 
+	// Public self-service wallet links (the token is the credential)
+	mux.HandleFunc("/wallet/", handlers.HandleWalletLink)
+
 	// Protected API endpoints
 	mux.Handle("/api/members", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleMembers)))
-	mux.Handle("/api/members/import", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleMembersImport)))
+	mux.Handle("/api/members/import", handlers.AuthMiddleware(handlers.RequirePermission(handlers.PermImportMembers, http.HandlerFunc(handlers.HandleMembersImport))))
 	mux.Handle("/api/members/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleMember)))
+	mux.Handle("/api/households", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleHouseholds)))
+	mux.Handle("/api/households/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleHouseholds)))
+	mux.Handle("/api/custom-fields", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleCustomFields)))
+	mux.Handle("/api/custom-fields/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleCustomFields)))
 	mux.Handle("/api/hikes", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleHikes)))
 	mux.Handle("/api/hikes/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleHike)))
 	mux.Handle("/api/checkins", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleCheckins)))
 	mux.Handle("/api/checkins/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleCheckins)))
 	mux.Handle("/api/rsvps/", handlers.AuthMiddleware(http.HandlerFunc(handleRSVPRoutes)))
 	mux.Handle("/api/activities/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleActivity)))
-	mux.Handle("/api/reports/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleReports)))
+	mux.Handle("/api/alerts", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleAlerts)))
+	mux.Handle("/api/alerts/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleAlerts)))
+	mux.Handle("/api/tokens", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleTokens)))
+	mux.Handle("/api/tokens/", handlers.AuthMiddleware(http.HandlerFunc(handlers.HandleTokens)))
+	mux.Handle("/api/admin/backup", handlers.AuthMiddleware(handlers.RequirePermission(handlers.PermBackup, http.HandlerFunc(handlers.HandleBackup))))
+	mux.Handle("/api/audit", handlers.AuthMiddleware(handlers.RequirePermission(handlers.PermViewReports, http.HandlerFunc(handlers.HandleAudit))))
+	mux.Handle("/api/reports/", handlers.AuthMiddleware(handlers.RequirePermission(handlers.PermViewReports, http.HandlerFunc(handlers.HandleReports))))
 
 	// Serve frontend static files
 	frontend := http.FileServer(http.Dir("frontend"))
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Member struct {
 	ID               int64     `json:"id"`
@@ -12,6 +15,54 @@ type Member struct {
 	Active           bool      `json:"active"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
+	// Bumped when a card is lost; cards printed with an older version are refused
+	CardVersion int `json:"card_version"`
+	// From the member's latest membership period; an empty end means it never expires
+	MembershipType    string `json:"membership_type,omitempty"`
+	MembershipEnd     string `json:"membership_end,omitempty"`
+	MembershipExpired bool   `json:"membership_expired"`
+	// Set for members of a couple or family membership
+	HouseholdID   *int64 `json:"household_id,omitempty"`
+	HouseholdName string `json:"household_name,omitempty"`
+	// Whether emergency contact details are on file; the details themselves
+	// only appear on a hike's emergency roster
+	HasEmergencyInfo bool `json:"has_emergency_info"`
+	// Values of the club's custom fields, by field key
+	CustomFields map[string]string `json:"custom_fields,omitempty"`
+}
+
+// User roles
+const (
+	RoleAdmin  = "admin"
+	RoleLeader = "leader"
+	RoleViewer = "viewer"
+)
+
+type User struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Username  string    `json:"username"`
+	PINHash   string    `json:"-"`
+	Role      string    `json:"role"` // "admin", "leader" or "viewer"
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HikeLeadersRequest replaces the users assigned to lead a hike
+type HikeLeadersRequest struct {
+	Usernames []string `json:"usernames"`
+}
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	ReadOnly   bool       `json:"read_only"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Joined fields
+	Username string `json:"username,omitempty"`
 }
 
 type Hike struct {
@@ -35,9 +86,17 @@ type Checkin struct {
 	Synced      bool      `json:"synced"`
 	IsLeader    bool      `json:"is_leader"`
 	IsSweeper   bool      `json:"is_sweeper"`
+	// Set for check-ins captured offline and uploaded later
+	DeviceID string     `json:"device_id,omitempty"`
+	SyncedAt *time.Time `json:"synced_at,omitempty"`
+	// Set once the member is confirmed back at the end of the hike
+	CheckedOutAt   *time.Time `json:"checked_out_at,omitempty"`
+	CheckoutMethod string     `json:"checkout_method,omitempty"` // "scan" or "manual"
 	// Joined fields for display
 	MemberName       string `json:"member_name,omitempty"`
 	MembershipNumber string `json:"membership_number,omitempty"`
+	// The member had no membership covering the hike date, so the leader should ask them to renew
+	Expired bool `json:"expired"`
 }
 
 type Activity struct {
@@ -67,6 +126,8 @@ type CreateMemberRequest struct {
 	LastName         string `json:"last_name"`
 	Email            string `json:"email,omitempty"`
 	Phone            string `json:"phone,omitempty"`
+	// Custom field values by field key
+	CustomFields map[string]string `json:"custom_fields,omitempty"`
 }
 
 type UpdateMemberRequest struct {
@@ -76,6 +137,210 @@ type UpdateMemberRequest struct {
 	Email            string `json:"email,omitempty"`
 	Phone            string `json:"phone,omitempty"`
 	Active           *bool  `json:"active,omitempty"`
+	// Only the fields given are changed; an empty value clears one
+	CustomFields map[string]string `json:"custom_fields,omitempty"`
+}
+
+// Member import modes
+const (
+	ImportInsert = "insert" // add new members and skip existing ones
+	ImportUpsert = "upsert" // add new members and update existing ones
+	ImportSync   = "sync"   // upsert, then deactivate active members missing from the file
+)
+
+// Import row actions
+const (
+	ImportCreate     = "create"
+	ImportUpdate     = "update"
+	ImportUnchanged  = "unchanged"
+	ImportSkip       = "skip"
+	ImportDeactivate = "deactivate"
+	ImportError      = "error"
+)
+
+// ImportedMember is a member as it will be after an import. ID is set for
+// existing members. CustomFields holds only the values being changed.
+type ImportedMember struct {
+	ID               int64
+	MembershipNumber string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	Active           bool
+	CustomFields     map[string]string
+}
+
+type ImportFieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImportRowResult reports what an import did, or would do, with one CSV line.
+// Deactivations in sync mode have no line.
+type ImportRowResult struct {
+	Line             int                          `json:"line,omitempty"`
+	MembershipNumber string                       `json:"membership_number,omitempty"`
+	Name             string                       `json:"name,omitempty"`
+	Action           string                       `json:"action"`
+	Changes          map[string]ImportFieldChange `json:"changes,omitempty"`
+	Reason           string                       `json:"reason,omitempty"` // why a row was skipped or rejected
+}
+
+// ImportResult summarises a member import. Nothing is saved on a dry run or
+// when any row has an error.
+type ImportResult struct {
+	Mode        string `json:"mode"`
+	DryRun      bool   `json:"dry_run"`
+	Committed   bool   `json:"committed"`
+	Created     int    `json:"created"`
+	Updated     int    `json:"updated"`
+	Unchanged   int    `json:"unchanged"`
+	Deactivated int    `json:"deactivated"`
+	// Imported (created plus updated), Skipped and Errors are the counts older clients read
+	Imported      int               `json:"imported"`
+	Skipped       int               `json:"skipped"`
+	Errors        int               `json:"errors"`
+	ErrorMessages []string          `json:"error_messages,omitempty"`
+	Rows          []ImportRowResult `json:"rows"`
+}
+
+// DuplicateCandidate is a pair of member records that may be the same person
+type DuplicateCandidate struct {
+	Member Member `json:"member"`
+	Match  Member `json:"match"`
+	// Name similarity from 0 to 100
+	NameScore int `json:"name_score"`
+	// Why the pair was flagged: "name", "email" and/or "phone"
+	Reasons []string `json:"reasons"`
+}
+
+// MergeMembersRequest merges the duplicate into the member in the URL
+type MergeMembersRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+// MergeResult counts what a merge moved to the surviving member. Records the
+// survivor already had for the same hike are combined rather than moved.
+type MergeResult struct {
+	Survivor       *Member `json:"survivor"`
+	CheckinsMoved  int     `json:"checkins_moved"`
+	CheckinsMerged int     `json:"checkins_merged"`
+	RSVPsMoved     int     `json:"rsvps_moved"`
+	RSVPsMerged    int     `json:"rsvps_merged"`
+	PeriodsMoved   int     `json:"periods_moved"`
+}
+
+// Custom field types
+const (
+	FieldText    = "text"
+	FieldNumber  = "number"
+	FieldDate    = "date"
+	FieldBoolean = "boolean"
+	FieldSelect  = "select"
+)
+
+// CustomField is a club-defined member field. Key names the field in the API
+// and in CSV files; Label is shown to users and used as the CSV column header.
+type CustomField struct {
+	ID        int64     `json:"id"`
+	Key       string    `json:"key"`
+	Label     string    `json:"label"`
+	Type      string    `json:"type"`
+	Required  bool      `json:"required"`
+	Options   []string  `json:"options,omitempty"` // the choices for a select field
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CustomFieldRequest struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"`
+	Position int      `json:"position"`
+}
+
+// Membership types
+const (
+	MembershipAnnual  = "annual"
+	MembershipStudent = "student"
+	MembershipFamily  = "family"
+	MembershipLife    = "life"
+)
+
+// MembershipPeriod is one paid-up period of a member's membership. Dates
+// are YYYY-MM-DD and inclusive; life memberships have no end date.
+type MembershipPeriod struct {
+	ID        int64     `json:"id"`
+	MemberID  int64     `json:"member_id"`
+	Type      string    `json:"type"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RenewMembershipRequest adds a membership period. Everything is optional:
+// by default the member's last type is renewed for a year, starting the day
+// after their current period ends or today if it has already lapsed.
+type RenewMembershipRequest struct {
+	Type      string `json:"type,omitempty"`
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+}
+
+// Household groups the members of a couple or family membership. The
+// primary contact is one of its members.
+type Household struct {
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"`
+	PrimaryMemberID *int64    `json:"primary_member_id,omitempty"`
+	Members         []Member  `json:"members"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// HouseholdRequest creates or replaces a household. MemberIDs is the full
+// membership; members already in another household are moved.
+type HouseholdRequest struct {
+	Name            string  `json:"name"`
+	PrimaryMemberID *int64  `json:"primary_member_id,omitempty"`
+	MemberIDs       []int64 `json:"member_ids"`
+}
+
+// HouseholdCheckinRequest checks in selected members of the household whose
+// card was scanned
+type HouseholdCheckinRequest struct {
+	HikeID           int64   `json:"hike_id"`
+	MembershipNumber string  `json:"membership_number"` // the scanned card
+	MemberIDs        []int64 `json:"member_ids"`
+	Manual           bool    `json:"manual,omitempty"`
+}
+
+// HouseholdAttendance rolls up a year's check-ins by the members of a household
+type HouseholdAttendance struct {
+	HouseholdID    int64  `json:"household_id"`
+	Name           string `json:"name"`
+	PrimaryContact string `json:"primary_contact,omitempty"`
+	Members        int    `json:"members"`
+	HikesAttended  int    `json:"hikes_attended"` // hikes with at least one member there
+	Checkins       int    `json:"checkins"`
+	LastHike       string `json:"last_hike,omitempty"`
+}
+
+// LapsedAttendance is a check-in by a member whose membership didn't cover the hike date
+type LapsedAttendance struct {
+	HikeID           int64  `json:"hike_id"`
+	HikeDate         string `json:"hike_date"`
+	HikeName         string `json:"hike_name"`
+	MemberID         int64  `json:"member_id"`
+	MembershipNumber string `json:"membership_number"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+	Email            string `json:"email,omitempty"`
+	// The end of the member's last period before the hike, empty if they had only joined later
+	LapsedSince string `json:"lapsed_since,omitempty"`
 }
 
 type CreateHikeRequest struct {
@@ -92,17 +357,229 @@ type UpdateHikeRequest struct {
 	Notes    string `json:"notes,omitempty"`
 }
 
+type ReopenHikeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CloseHikeRequest is optional; Override closes the hike even though
+// some participants have not been checked out
+type CloseHikeRequest struct {
+	Override bool   `json:"override"`
+	Reason   string `json:"reason,omitempty"`
+}
+
 type CreateCheckinRequest struct {
 	HikeID           int64  `json:"hike_id"`
 	MembershipNumber string `json:"membership_number"`
+	// When the member was scanned on the device, for offline check-ins
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+	// Client-generated UUID so retried uploads aren't applied twice
+	ClientID string `json:"client_id,omitempty"`
+	// Set when a leader picked the member from a list instead of scanning a
+	// card, so a plain membership number is accepted
+	Manual bool `json:"manual,omitempty"`
 }
 
 type BulkCheckinRequest struct {
-	Checkins []CreateCheckinRequest `json:"checkins"`
+	DeviceID  string                 `json:"device_id,omitempty"`
+	Checkins  []CreateCheckinRequest `json:"checkins"`
+	Incidents []IncidentRequest      `json:"incidents,omitempty"`
+}
+
+// Outcomes for each item of a bulk check-in. Every status except
+// BulkStatusError is final, so the device can drop the item from its queue.
+const (
+	BulkStatusCreated        = "created"
+	BulkStatusDuplicate      = "duplicate"
+	BulkStatusMemberNotFound = "member_not_found"
+	BulkStatusHikeClosed     = "hike_closed"
+	BulkStatusHikeNotFound   = "hike_not_found"
+	BulkStatusCardRejected   = "card_rejected"
+	BulkStatusInvalid        = "invalid"
+	BulkStatusError          = "error"
+)
+
+type BulkCheckinResult struct {
+	ClientID         string   `json:"client_id,omitempty"`
+	MembershipNumber string   `json:"membership_number"`
+	Status           string   `json:"status"`
+	Checkin          *Checkin `json:"checkin,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+type CheckoutRequest struct {
+	HikeID           int64  `json:"hike_id"`
+	MembershipNumber string `json:"membership_number"`
+}
+
+// HeadcountEntry is one person who started a hike, member or guest
+type HeadcountEntry struct {
+	CheckinID        *int64     `json:"checkin_id,omitempty"`
+	RSVPID           *int64     `json:"rsvp_id,omitempty"`
+	Name             string     `json:"name"`
+	MembershipNumber string     `json:"membership_number,omitempty"`
+	IsGuest          bool       `json:"is_guest"`
+	CheckedInAt      time.Time  `json:"checked_in_at"`
+	CheckedOutAt     *time.Time `json:"checked_out_at,omitempty"`
+	Returned         bool       `json:"returned"`
+}
+
+type Headcount struct {
+	HikeID      int64            `json:"hike_id"`
+	Started     int              `json:"started"`
+	Returned    int              `json:"returned"`
+	Outstanding int              `json:"outstanding"`
+	People      []HeadcountEntry `json:"people"`
+}
+
+// Alert types
+const AlertOverdue = "overdue"
+
+// HikeAlert is raised when a group is overdue off the trail. The emergency
+// sheet is stored as it was when the alert was raised.
+type HikeAlert struct {
+	ID              int64           `json:"id"`
+	HikeID          int64           `json:"hike_id"`
+	Type            string          `json:"type"`
+	Notes           string          `json:"notes,omitempty"`
+	RaisedBy        string          `json:"raised_by"`
+	RaisedAt        time.Time       `json:"raised_at"`
+	ResolvedBy      string          `json:"resolved_by,omitempty"`
+	ResolvedAt      *time.Time      `json:"resolved_at,omitempty"`
+	ResolutionNotes string          `json:"resolution_notes,omitempty"`
+	Sheet           *EmergencySheet `json:"sheet,omitempty"`
+	// Joined fields
+	HikeName string `json:"hike_name,omitempty"`
+}
+
+type RaiseAlertRequest struct {
+	Notes string `json:"notes,omitempty"`
+}
+
+type ResolveAlertRequest struct {
+	Notes string `json:"notes"`
+}
+
+// EmergencySheet is what search and rescue need when a group is overdue
+type EmergencySheet struct {
+	HikeID      int64                  `json:"hike_id"`
+	HikeName    string                 `json:"hike_name"`
+	HikeDate    string                 `json:"hike_date"`
+	Location    string                 `json:"location,omitempty"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Started     int                    `json:"started"`
+	Leaders     []EmergencySheetPerson `json:"leaders"`
+	Sweepers    []EmergencySheetPerson `json:"sweepers"`
+	Outstanding []EmergencySheetPerson `json:"outstanding"`
+}
+
+type EmergencySheetPerson struct {
+	Name             string     `json:"name"`
+	MembershipNumber string     `json:"membership_number,omitempty"`
+	Phone            string     `json:"phone,omitempty"`
+	IsGuest          bool       `json:"is_guest"`
+	IsLeader         bool       `json:"is_leader"`
+	IsSweeper        bool       `json:"is_sweeper"`
+	CheckedInAt      time.Time  `json:"checked_in_at"`
+	CheckedOutAt     *time.Time `json:"checked_out_at,omitempty"`
+}
+
+// EmergencyInfo is stored encrypted and only shown on a hike's emergency roster
+type EmergencyInfo struct {
+	ContactName  string `json:"emergency_contact_name"`
+	ContactPhone string `json:"emergency_contact_phone"`
+	MedicalNotes string `json:"medical_notes,omitempty"`
+}
+
+// EmergencyRoster lists everyone on a hike with their emergency details
+type EmergencyRoster struct {
+	HikeID      int64                  `json:"hike_id"`
+	HikeName    string                 `json:"hike_name"`
+	HikeDate    string                 `json:"hike_date"`
+	Location    string                 `json:"location,omitempty"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Entries     []EmergencyRosterEntry `json:"entries"`
+}
+
+type EmergencyRosterEntry struct {
+	MemberID         *int64         `json:"member_id,omitempty"`
+	Name             string         `json:"name"`
+	MembershipNumber string         `json:"membership_number,omitempty"`
+	Phone            string         `json:"phone,omitempty"`
+	IsGuest          bool           `json:"is_guest"`
+	IsLeader         bool           `json:"is_leader"`
+	IsSweeper        bool           `json:"is_sweeper"`
+	EmergencyInfo    *EmergencyInfo `json:"emergency_info,omitempty"`
+}
+
+// Incident severities, least to most serious
+const (
+	SeverityNearMiss = "near_miss"
+	SeverityMinor    = "minor"
+	SeverityModerate = "moderate"
+	SeveritySerious  = "serious"
+)
+
+// Incident is an injury or near-miss logged against a hike
+type Incident struct {
+	ID           int64            `json:"id"`
+	HikeID       int64            `json:"hike_id"`
+	Severity     string           `json:"severity"`
+	Description  string           `json:"description"`
+	ActionsTaken string           `json:"actions_taken,omitempty"`
+	ReportedBy   string           `json:"reported_by"`
+	OccurredAt   *time.Time       `json:"occurred_at,omitempty"`
+	DeviceID     string           `json:"device_id,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	People       []IncidentPerson `json:"people"`
+	// Joined fields
+	HikeName string `json:"hike_name,omitempty"`
+	HikeDate string `json:"hike_date,omitempty"`
+}
+
+// IncidentPerson is a checked-in member involved in an incident
+type IncidentPerson struct {
+	CheckinID        int64  `json:"checkin_id"`
+	MemberID         int64  `json:"member_id"`
+	Name             string `json:"name"`
+	MembershipNumber string `json:"membership_number"`
+}
+
+// IncidentRequest creates or replaces an incident. People can be given by
+// check-in ID or, for devices that logged them offline, by membership number.
+type IncidentRequest struct {
+	ClientID          string     `json:"client_id,omitempty"`
+	HikeID            int64      `json:"hike_id,omitempty"`
+	Severity          string     `json:"severity"`
+	Description       string     `json:"description"`
+	ActionsTaken      string     `json:"actions_taken"`
+	OccurredAt        *time.Time `json:"occurred_at,omitempty"`
+	CheckinIDs        []int64    `json:"checkin_ids,omitempty"`
+	MembershipNumbers []string   `json:"membership_numbers,omitempty"`
+}
+
+type BulkIncidentResult struct {
+	ClientID string    `json:"client_id,omitempty"`
+	Status   string    `json:"status"`
+	Incident *Incident `json:"incident,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name     string `json:"name"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// CreateAPITokenResponse is the only time the raw token is returned
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
 }
 
 type AuthRequest struct {
-	PIN string `json:"pin"`
+	Username string `json:"username"`
+	PIN      string `json:"pin"`
 }
 
 type MemberHistory struct {
@@ -120,12 +597,40 @@ type AttendanceRecord struct {
 	HikeDate         string    `json:"hike_date"`
 	HikeName         string    `json:"hike_name"`
 	HikeLocation     string    `json:"hike_location"`
+	MemberID         int64     `json:"member_id"`
 	MembershipNumber string    `json:"membership_number"`
 	FirstName        string    `json:"first_name"`
 	LastName         string    `json:"last_name"`
 	CheckedInAt      time.Time `json:"checked_in_at"`
 }
 
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	UserID     *int64          `json:"user_id,omitempty"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   *int64          `json:"entity_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type AuditFilter struct {
+	EntityType string
+	EntityID   *int64
+	From       string // YYYY-MM-DD, inclusive
+	To         string // YYYY-MM-DD, inclusive
+	Limit      int
+}
+
+// MemberFilter selects members for the member export
+type MemberFilter struct {
+	Active     *bool  // nil for active and inactive members
+	JoinedFrom string // YYYY-MM-DD, inclusive, against created_at
+	JoinedTo   string // YYYY-MM-DD, inclusive
+}
+
 type RSVP struct {
 	ID        int64     `json:"id"`
 	HikeID    int64     `json:"hike_id"`
@@ -136,11 +641,14 @@ type RSVP struct {
 	MemberName       string `json:"member_name,omitempty"`
 	MembershipNumber string `json:"membership_number,omitempty"`
 	CheckedIn        bool   `json:"checked_in"`
+	CheckedOut       bool   `json:"checked_out"`
 }
 
 type RSVPRequest struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	// First names of members of the same household coming along
+	Household []string `json:"household,omitempty"`
 }
 
 type RSVPResponse struct {
@@ -149,4 +657,7 @@ type RSVPResponse struct {
 	MatchedName  string `json:"matched_name,omitempty"`
 	MemberNumber string `json:"member_number,omitempty"`
 	IsGuest      bool   `json:"is_guest"`
+	// Household members registered alongside, and names that matched nobody in the household
+	Household []string `json:"household,omitempty"`
+	Unmatched []string `json:"unmatched,omitempty"`
 }